@@ -0,0 +1,83 @@
+package certcheck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/mackerelio/checkers"
+)
+
+// Result is the outcome of Check, with the cert data the print modes and the
+// json output of the command show. The cert fields are zero when no cert was read.
+type Result struct {
+	Status        checkers.Status
+	Message       string
+	NotAfter      time.Time
+	NotBefore     time.Time
+	DaysRemaining int64
+	Subjects      []string
+	Issuer        string
+	Serial        string
+	// Fingerprint is the SHA-256 fingerprint of the leaf in lower case hex
+	Fingerprint string
+	// SPKIPin is the base64 SHA-256 of the public key, as --print-spki-pin
+	SPKIPin  string
+	Protocol string
+	Cipher   string
+}
+
+// DefaultCheckOptions returns CheckOptions with the defaults of the command
+// line flags. Set Host and Port, or the other fields, before passing it to Check.
+// The error is only set when a default itself is invalid.
+func DefaultCheckOptions() (CheckOptions, error) {
+	opts := CheckOptions{}
+	if _, err := flags.NewParser(&opts, flags.None).ParseArgs([]string{}); err != nil {
+		return opts, fmt.Errorf("could not apply the defaults: %s", err)
+	}
+	return opts, nil
+}
+
+// Check checks the cert served by opts.Host like the check-cert-net command
+// does without --targets, --srv or --both, applying InspectURL, Service and
+// Now as the command does. The deadline of ctx bounds the check as
+// --deadline does, and canceling ctx stops the connection in progress. The
+// error is only set when ctx is done before the check finishes; a failed
+// check, or invalid opts, is reported by Result.Status.
+func Check(ctx context.Context, opts CheckOptions) (Result, error) {
+	if dl, ok := ctx.Deadline(); ok {
+		d := time.Until(dl)
+		if opts.Deadline == 0 || d < opts.Deadline {
+			opts.Deadline = d
+		}
+		if d < opts.Timeout {
+			opts.Timeout = d
+		}
+	}
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		return Result{Status: checkers.UNKNOWN, Message: err.Error()}, nil
+	}
+
+	ckr, cert := checkCertNet(ctx, opts)
+	if ctx.Err() != nil {
+		return Result{Status: checkers.UNKNOWN, Message: ctx.Err().Error()}, ctx.Err()
+	}
+	res := Result{Status: ckr.Status, Message: ckr.Message}
+	if cert != nil {
+		res.NotAfter = *cert.notAfter
+		if cert.notBefore != nil {
+			res.NotBefore = *cert.notBefore
+		}
+		res.DaysRemaining = daysRemaining(opts, cert)
+		res.Subjects = append([]string{}, cert.subjects...)
+		res.Issuer = cert.issuer
+		res.Serial = cert.serial
+		res.Fingerprint = cert.fingerprint
+		res.SPKIPin = cert.spkiPin
+		res.Protocol = cert.protocol
+		res.Cipher = cert.cipher
+	}
+	return res, nil
+}
@@ -0,0 +1,135 @@
+package certcheck
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mackerelio/checkers"
+)
+
+// defaultCheckOptions returns DefaultCheckOptions, failing t on an error
+func defaultCheckOptions(t *testing.T) CheckOptions {
+	t.Helper()
+	opts, err := DefaultCheckOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func TestDefaultCheckOptions(t *testing.T) {
+	opts, err := DefaultCheckOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Port != "443" || opts.Timeout != 5*time.Second || opts.OpenSSLPath != "openssl" {
+		t.Fatalf("defaults are not applied: %+v", opts)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	name := writeTempCert(t)
+
+	opts := defaultCheckOptions(t)
+	opts.CertFile = name
+	opts.Native = true
+	opts.SelfSigned = "ok"
+	res, err := Check(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != checkers.OK {
+		t.Fatalf("unexpected status %s: %s", res.Status, res.Message)
+	}
+	if len(res.Subjects) != 1 || res.Subjects[0] != "mail.example.com" {
+		t.Errorf("unexpected subjects %v", res.Subjects)
+	}
+	if res.NotAfter.Year() != 2036 || res.NotBefore.Year() != 2026 || res.DaysRemaining <= 0 {
+		t.Errorf("unexpected validity %s - %s, %d days", res.NotBefore, res.NotAfter, res.DaysRemaining)
+	}
+	if res.SPKIPin != "pzkeSaQ1FSExvMWqcwNCmWYen70ORftFAWYzYcCAuL8=" || len(res.Fingerprint) != 64 || res.Issuer != "CN = mail.example.com" {
+		t.Errorf("unexpected cert data %+v", res)
+	}
+}
+
+func TestCheckContextDeadline(t *testing.T) {
+	// a listener that never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	opts := defaultCheckOptions(t)
+	opts.Host, opts.Port, _ = net.SplitHostPort(ln.Addr().String())
+	opts.Native = true
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, _ := Check(ctx, opts)
+	if res.Status != checkers.UNKNOWN {
+		t.Fatalf("unexpected status %s: %s", res.Status, res.Message)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the context deadline is not enforced: %s", elapsed)
+	}
+}
+
+func TestCheckNativeNoECDSACertificate(t *testing.T) {
+	// httptest serves an RSA cert only
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	opts := defaultCheckOptions(t)
+	opts.Host, opts.Port, _ = net.SplitHostPort(ts.Listener.Addr().String())
+	opts.Native = true
+	opts.ECDSA = true
+	res, err := Check(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != checkers.CRITICAL || !strings.Contains(res.Message, "no ECDSA certificate served") {
+		t.Fatalf("unexpected result %s: %s", res.Status, res.Message)
+	}
+}
+
+func TestCheckAppliesNow(t *testing.T) {
+	name := writeTempCert(t)
+
+	opts := defaultCheckOptions(t)
+	opts.CertFile = name
+	opts.Native = true
+	opts.SelfSigned = "ok"
+	opts.Now = "2036-01-01T00:00:00Z"
+	res, _ := Check(context.Background(), opts)
+	if res.DaysRemaining != 284 {
+		t.Fatalf("Now is not applied: %s, %d days", res.Message, res.DaysRemaining)
+	}
+	opts.Now = "tomorrow"
+	if res, _ := Check(context.Background(), opts); res.Status != checkers.UNKNOWN {
+		t.Fatalf("invalid Now should be UNKNOWN: %s %s", res.Status, res.Message)
+	}
+}
+
+func TestCheckCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	opts := defaultCheckOptions(t)
+	opts.Host, opts.Port, _ = net.SplitHostPort(ln.Addr().String())
+	opts.Native = true
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := Check(ctx, opts); err != context.Canceled {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("canceling ctx does not stop the handshake: %s", elapsed)
+	}
+}
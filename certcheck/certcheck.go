@@ -0,0 +1,2462 @@
+// Package certcheck checks the TLS certificate served by an endpoint. It is the
+// core of the check-cert-net command and can be embedded with Check.
+package certcheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/kazeburo/check-cert-net/execpipe"
+	"github.com/mackerelio/checkers"
+	"golang.org/x/net/publicsuffix"
+)
+
+// CheckOptions are the command line options of check-cert-net, also used to
+// configure Check. Start from DefaultCheckOptions to get the flag defaults.
+type CheckOptions struct {
+	Host             string           `short:"H" long:"host" default:"localhost" description:"Hostname, or unix:/path/to/socket"`
+	Port             string           `short:"p" long:"port" default:"443" description:"Port, or comma separated ports to check each of them"`
+	ResolveCNAME     bool             `long:"resolve-cname" description:"Follow the CNAME of the host and connect to its address with the host as servername"`
+	Targets          string           `long:"targets" description:"Comma separated host:port targets to check concurrently. The port defaults to --port"`
+	TargetsFile      string           `long:"targets-file" description:"File listing host:port targets, one per line, optionally with warning= and critical= overrides. Lines starting with # are ignored"`
+	Concurrency      int              `long:"concurrency" default:"4" description:"Maximum number of targets checked at the same time"`
+	Watch            bool             `long:"watch" description:"Keep checking the targets every --interval and print a line only when the status of a target changes"`
+	Interval         time.Duration    `long:"interval" default:"1m" description:"Interval between the rounds of --watch"`
+	RateLimit        float64          `long:"rate-limit" description:"Maximum number of target checks started per second, on top of --concurrency (e.g. 0.5 for one every 2s)"`
+	SRV              string           `long:"srv" description:"Check every endpoint discovered by the SRV record (_service._proto.domain)"`
+	Deadline         time.Duration    `long:"deadline" description:"Overall time limit of the check including retries, or of all targets in multi-target runs. Unfinished checks become UNKNOWN"`
+	Service          string           `long:"service" choice:"registry" description:"Apply defaults for a known service"`
+	InspectURL       string           `long:"inspect-url" description:"https URL to derive host, port and servername from (TLS handshake only)"`
+	ServerName       string           `long:"servername" default:"" description:"servername in ClientHello"`
+	SNI              string           `long:"sni" description:"SNI sent in ClientHello instead of --servername. Not used for verification"`
+	VerifyName       string           `long:"verify-name" description:"Verify this name against the cert instead of --servername or host"`
+	VerifyServerName bool             `long:"verify-servername" description:"verify servername, or host when servername is not given"`
+	StrictSNI        bool             `long:"strict-sni" description:"Critical unless the DNS SANs cover the SNI sent, reporting what the server returned instead"`
+	TestName         string           `long:"test-name" description:"Concrete name sent as servername and verified against the cert (e.g. www.example.com for *.example.com)"`
+	SourceAddr       string           `long:"source-addr" description:"Local IP address to connect from"`
+	Proxy            string           `long:"proxy" description:"HTTP CONNECT proxy (host:port) to tunnel through"`
+	ProxyAuth        string           `long:"proxy-auth" description:"user:pass for the proxy basic authentication"`
+	SOCKS5           string           `long:"socks5" description:"SOCKS5 proxy (host:port) to connect through. Requires --native"`
+	ALPN             string           `long:"alpn" description:"Comma separated ALPN protocols to offer (e.g. h2,http/1.1)"`
+	Renegotiate      string           `long:"renegotiate" description:"GET this path and report the cert the server sends when it renegotiates for it (--native only, TLS 1.2)"`
+	ClientCert       string           `long:"client-cert" description:"PEM client certificate presented for mutual TLS"`
+	ClientKey        string           `long:"client-key" description:"PEM private key of --client-cert"`
+	QuitString       string           `long:"quit-string" default:"QUIT" description:"Line sent to s_client to close the session"`
+	StartTLS         string           `long:"starttls" description:"Use STARTTLS for the protocol (smtp, imap, pop3, ftp, postgres or mysql)"`
+	OpenSSLPath      string           `long:"openssl-path" default:"openssl" description:"Path to the openssl command"`
+	CertFile         string           `long:"cert-file" description:"Read the cert from a PEM file instead of connecting to the host"`
+	Native           bool             `long:"native" description:"Use Go crypto/tls instead of the openssl command"`
+	Timeout          time.Duration    `long:"timeout" default:"5s" description:"Timeout to connect to server. CHECK_CERT_TIMEOUT is used when not given"`
+	DNSTimeout       time.Duration    `long:"dns-timeout" description:"Resolve the host separately within this time before connecting (--native only)"`
+	TCPKeepAlive     time.Duration    `long:"tcp-keepalive" description:"TCP keep-alive period of the connection, negative to disable (--native only). Defaults to Go's 15s"`
+	TCPNoDelay       string           `long:"tcp-nodelay" choice:"on" choice:"off" description:"TCP_NODELAY of the connection; off enables Nagle's algorithm (--native only). Defaults to on"`
+	Retries          int              `long:"retries" description:"Retry the connection this many times on connection errors and timeouts"`
+	RetryInterval    time.Duration    `long:"retry-interval" default:"1s" description:"Wait before the first retry, doubled on every retry up to 1m"`
+	RSA              bool             `long:"rsa" description:"Preferred aRSA cipher to use"`
+	ECDSA            bool             `long:"ecdsa" description:"Preferred aECDSA cipher to use"`
+	CAFile           string           `long:"ca-file" description:"PEM bundle of trusted roots for --verify-chain instead of the system roots"`
+	VerifyChain      bool             `long:"verify-chain" description:"Critical when the chain does not verify to a trusted root"`
+	RequireRoot      string           `long:"require-root" description:"Critical unless the chain verifies to the root of this subject substring or SHA-256 fingerprint"`
+	CompleteChain    bool             `long:"require-complete-chain" description:"Critical when the chain sent by the server lacks an intermediate to reach a trusted root"`
+	CheckOCSP        bool             `long:"check-ocsp" description:"Critical when the stapled OCSP response says revoked, warning when none is stapled"`
+	Dane             bool             `long:"dane" description:"Critical when the cert does not match the TLSA records of _port._tcp.host (RFC 6698)"`
+	ShowCipher       bool             `long:"show-cipher" description:"Add the negotiated cipher suite to the message"`
+	MinTLSVersion    string           `long:"min-tls-version" choice:"1.0" choice:"1.1" choice:"1.2" choice:"1.3" description:"Critical when an older TLS version is negotiated"`
+	Both             bool             `long:"both" description:"Check both the aRSA and aECDSA certs"`
+	CheckChain       bool             `long:"check-chain" description:"Check the expiry of every cert in the chain and report the soonest one"`
+	LeafInIssuer     bool             `long:"verify-leaf-within-issuer" description:"Warning when the leaf expires after the intermediate that issued it"`
+	Crit             Threshold        `short:"c" long:"critical" default:"14" description:"The critical threshold before expiry. Days, or with a unit (48h, 30d, 2w)"`
+	Warn             Threshold        `short:"w" long:"warning" default:"30" description:"The threshold before expiry. Days, or with a unit (48h, 30d, 2w)"`
+	ChainCrit        *Threshold       `long:"chain-critical" description:"--critical for the certs in the chain other than the leaf with --check-chain. Defaults to --critical"`
+	ChainWarn        *Threshold       `long:"chain-warning" description:"--warning for the certs in the chain other than the leaf with --check-chain. Defaults to --warning"`
+	NoPerfdata       bool             `long:"no-perfdata" description:"Do not append performance data to the message"`
+	Emergency        Threshold        `long:"emergency" description:"The emergency threshold before expiry, like --critical. Reported as CRITICAL with an EMERGENCY marker"`
+	EmergencyExit    int              `long:"emergency-exit-code" description:"Exit code used instead of 2 when the emergency threshold is hit"`
+	RequireValidFor  time.Duration    `long:"require-valid-for" description:"Critical if the cert expires within this duration (e.g. 72h)"`
+	ExpectedIssuer   string           `long:"expected-issuer" description:"Critical when the issuer DN does not contain this string"`
+	IssuerAllowlist  string           `long:"issuer-allowlist" description:"@file listing acceptable issuer CN or O, one per line"`
+	StatsdAddr       string           `long:"statsd-addr" description:"statsd address (host:port) to send the result to over UDP"`
+	StatsdPrefix     string           `long:"statsd-prefix" default:"check_cert_net" description:"Metric name prefix for statsd"`
+	SANCountMin      int              `long:"san-count-min" description:"Warn when the cert has fewer DNS SANs than this"`
+	SANCountMax      int              `long:"san-count-max" description:"Warn when the cert has more DNS SANs than this"`
+	RefusedSeverity  string           `long:"refused-severity" default:"critical" choice:"warning" choice:"critical" choice:"unknown" description:"Status when the connection is refused"`
+	TimeoutSeverity  string           `long:"timeout-severity" default:"critical" choice:"warning" choice:"critical" choice:"unknown" description:"Status when the connection times out"`
+	SCTWithin        time.Duration    `long:"sct-within" description:"Warn when an embedded SCT timestamp is further than this from notBefore"`
+	MinRSABits       int              `long:"min-rsa-bits" description:"Critical when the RSA key is smaller than this many bits"`
+	RejectCurves     string           `long:"reject-curves" description:"Comma separated EC curves to reject (e.g. P-224,secp256k1)"`
+	RejectSigAlgs    string           `long:"reject-sig-algs" description:"Comma separated signature algorithms to reject (e.g. sha1WithRSAEncryption,md5WithRSAEncryption)"`
+	SigAlgSeverity   string           `long:"sig-alg-severity" default:"warning" choice:"warning" choice:"critical" description:"Status when the signature algorithm is rejected"`
+	SHA1InChain      bool             `long:"warn-on-sha1-in-chain" description:"Report the certs in the chain, other than self-signed roots, signed with SHA-1 at --sig-alg-severity"`
+	SelfSigned       string           `long:"self-signed-severity" default:"warning" choice:"ok" choice:"warning" choice:"critical" description:"Status when the leaf is self-signed"`
+	Fingerprint      string           `long:"fingerprint" description:"Expected SHA-256 fingerprint of the leaf in hex, colons optional"`
+	ExpectedSerial   string           `long:"expected-serial" description:"Expected serial number of the leaf, in decimal or hex as openssl shows it (e.g. 4096 or 3a:bc:01)"`
+	PinFromTXT       string           `long:"pin-from-txt" description:"DNS name of a TXT record publishing the expected leaf SHA-256 fingerprint(s)"`
+	RequireSortedSAN bool             `long:"require-sorted-san" description:"Warn when the DNS SANs are duplicated or not sorted"`
+	AuditSANs        bool             `long:"audit-sans" description:"Warn about IP addresses in DNS SANs, SANs overlapping a wildcard and a CN missing from the SANs"`
+	CriticalBefore   string           `long:"critical-before" description:"Critical when the cert expires before this date (RFC3339 or 2006-01-02)"`
+	WarningBefore    string           `long:"warning-before" description:"Warning when the cert expires before this date (RFC3339 or 2006-01-02)"`
+	NoExpiryBetween  string           `long:"no-expiry-between" description:"START,END RFC3339 window in which the cert must not expire"`
+	MatchField       []string         `long:"match-field" description:"field=regex the cert must match. field is issuer, subject, san or serial. Repeatable"`
+	RequireCNMatch   bool             `long:"require-cn-match" description:"Warn when the servername, or host, is not in the CN for clients that ignore SANs"`
+	StrictSubject    bool             `long:"strict-subject" description:"Warn when the cert relies on deprecated Subject practices"`
+	DateFormat       string           `long:"date-format" default:"2006-01-02" description:"Go time layout used to display the expiry date"`
+	Timezone         string           `long:"timezone" default:"UTC" description:"IANA time zone used to display the expiry date"`
+	Template         string           `long:"template" description:"Go text/template for the message. Fields: NotAfter, DaysRemaining, Subjects, Issuer, Serial, Status, Message"`
+	Format           string           `long:"format" default:"text" choice:"text" choice:"csv" choice:"json" choice:"jsonl" description:"Output format. csv and jsonl write one row per target"`
+	IncludePEM       bool             `long:"include-pem" description:"Add the PEM of every cert sent by the server as chain_pem to the json and jsonl output"`
+	Metric           bool             `long:"metric" description:"Print days remaining as Mackerel metrics (name, value and time separated by tabs) instead of the message"`
+	PrintExpiry      bool             `long:"print-expiry" description:"Print only the expiry date and exit"`
+	PrintSPKIPin     bool             `long:"print-spki-pin" description:"Print only the HPKP pin-sha256 of the leaf and exit"`
+	Now              string           `long:"now" description:"RFC3339 time used instead of the current time for validity computations"`
+	Clock            func() time.Time `no-flag:"true"`
+	Debug            bool             `long:"debug" description:"Print the openssl command lines and their stderr to stderr"`
+	CheckConfig      bool             `long:"check-config" description:"Validate the options and files without connecting, then exit"`
+	Version          bool             `short:"v" long:"version" description:"Show version"`
+
+	// checker checks a single target of checkTargets, checkSRV and
+	// checkBoth, checkCertNet when nil. Replaced in tests.
+	checker func(context.Context, CheckOptions) (*checkers.Checker, *certInfo)
+}
+
+// Threshold is a duration before expiry. A bare integer means days.
+type Threshold time.Duration
+
+// Days returns a Threshold of n days, e.g. Days(30) for --warning 30
+func Days(n int) Threshold {
+	return Threshold(time.Duration(n) * 24 * time.Hour)
+}
+
+// UnmarshalFlag parses days ("30"), days or weeks ("30d", "2w") or a Go duration ("48h")
+func (t *Threshold) UnmarshalFlag(value string) error {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*t = Days(int(n))
+		return nil
+	}
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	if len(value) > 1 {
+		if unit, ok := units[value[len(value)-1:]]; ok {
+			n, err := strconv.ParseFloat(value[:len(value)-1], 64)
+			if err != nil {
+				return fmt.Errorf("invalid threshold: %s", value)
+			}
+			*t = Threshold(time.Duration(n * float64(unit)))
+			return nil
+		}
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid threshold: %s", value)
+	}
+	*t = Threshold(d)
+	return nil
+}
+
+// days returns the threshold in days, rounded to two decimals
+func (t Threshold) days() float64 {
+	return math.Round(time.Duration(t).Hours()/24*100) / 100
+}
+
+type certInfo struct {
+	notAfter      *time.Time
+	notBefore     *time.Time
+	sctTimestamps []time.Time
+	subjects      []string
+	commonNames   []string
+	subject       string
+	sans          []string
+	issuer        string
+	serial        string
+	fingerprint   string
+	spkiPin       string
+	keyAlgorithm  string
+	keyBits       int
+	curve         string
+	sigAlgorithm  string
+	chain         []*certInfo
+	protocol      string
+	cipher        string
+	ocspStatus    string
+	der           []byte
+	verifyError   string
+	selfSigned    bool
+}
+
+var layout = "Jan 2 15:04:05 2006 MST"
+
+// connError is a transport level failure classified from the s_client output
+type connError struct {
+	reason string
+}
+
+func (e *connError) Error() string {
+	return e.reason
+}
+
+// usageError is a configuration or tooling problem, reported as Unknown
+// rather than as a bad certificate
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+var connErrorReasons = []struct {
+	output string
+	reason string
+}{
+	{"Connection refused", "connection refused"},
+	{"Connection timed out", "connection timed out"},
+	{"No route to host", "no route to host"},
+}
+
+func classifyConnError(stderr string) error {
+	for _, r := range connErrorReasons {
+		if strings.Contains(stderr, r.output) {
+			return &connError{r.reason}
+		}
+	}
+	return nil
+}
+
+var severities = map[string]checkers.Status{
+	"warning":  checkers.WARNING,
+	"critical": checkers.CRITICAL,
+	"unknown":  checkers.UNKNOWN,
+}
+
+// outputTail describes the last n non-empty lines of out for error messages
+func outputTail(out []byte, n int) string {
+	lines := make([]string, 0)
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) == 0 {
+		return "empty output"
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return "output ends with: " + strings.Join(lines, " | ")
+}
+
+func fmtString(s string) string {
+	out := strings.TrimRight(s, "\n")
+	out = strings.NewReplacer(
+		"\r\n", "\\r\\n",
+		"\r", "\\r",
+		"\n", "\\n",
+	).Replace(out)
+	return out
+}
+
+// applyService applies the defaults of opts.Service.
+// registry: servername defaults to the host and is verified. Only the TLS
+// handshake is performed, so registries behind auth need no credentials.
+func applyService(opts CheckOptions) CheckOptions {
+	switch opts.Service {
+	case "registry":
+		if opts.ServerName == "" {
+			opts.ServerName = opts.Host
+		}
+		opts.VerifyServerName = true
+	}
+	return opts
+}
+
+// unixSocket returns the socket path of a unix:/path host
+func unixSocket(opts CheckOptions) (string, bool) {
+	if strings.Index(opts.Host, "unix:") != 0 {
+		return "", false
+	}
+	return opts.Host[len("unix:"):], true
+}
+
+// endpoint names the target in messages: host:port or unix:/path
+func endpoint(opts CheckOptions) string {
+	if _, ok := unixSocket(opts); ok {
+		return opts.Host
+	}
+	return net.JoinHostPort(opts.Host, opts.Port)
+}
+
+// splitHost splits a bracketed IPv6 host with a port ("[2001:db8::1]:8443")
+// and strips the brackets of one without a port. Other hosts are returned as is.
+func splitHost(host, port string) (string, string) {
+	if strings.Index(host, "[") != 0 {
+		return host, port
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		return h, p
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), port
+}
+
+// checkPort fails unless port is a TCP port number
+func checkPort(port string) error {
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return usageErrorf("invalid port %s: must be an integer between 1 and 65535", port)
+	}
+	return nil
+}
+
+// checkSourceAddr fails unless addr is an IP address of a local interface
+func checkSourceAddr(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return usageErrorf("invalid --source-addr: %s is not an IP address", addr)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return usageErrorf("invalid --source-addr: %s", err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return usageErrorf("invalid --source-addr: %s is not assigned to this host", addr)
+}
+
+// applyInspectURL sets host, port and servername from opts.InspectURL
+func applyInspectURL(opts CheckOptions) (CheckOptions, error) {
+	u, err := url.Parse(opts.InspectURL)
+	if err != nil {
+		return opts, usageErrorf("invalid --inspect-url: %s", err)
+	}
+	if u.Scheme != "https" {
+		return opts, usageErrorf("invalid --inspect-url: scheme must be https")
+	}
+	if u.Hostname() == "" {
+		return opts, usageErrorf("invalid --inspect-url: no host in %s", opts.InspectURL)
+	}
+	opts.Host = u.Hostname()
+	opts.Port = "443"
+	if u.Port() != "" {
+		opts.Port = u.Port()
+	}
+	if opts.ServerName == "" {
+		opts.ServerName = u.Hostname()
+	}
+	return opts, nil
+}
+
+// authAlgorithm returns the key algorithm asked by --rsa or --ecdsa, or ""
+func authAlgorithm(opts CheckOptions) string {
+	if opts.RSA {
+		return "RSA"
+	}
+	if opts.ECDSA {
+		return "ECDSA"
+	}
+	return ""
+}
+
+// keyAlgorithms are the key algorithms accepted for --rsa and --ecdsa as
+// named by openssl
+var keyAlgorithms = map[string][]string{
+	"RSA":   {"rsaEncryption", "rsassaPss"},
+	"ECDSA": {"id-ecPublicKey"},
+}
+
+// keyMatches reports whether the cert key is of the algorithm alg
+func keyMatches(alg, keyAlgorithm string) bool {
+	for _, a := range keyAlgorithms[alg] {
+		if a == keyAlgorithm {
+			return true
+		}
+	}
+	return false
+}
+
+var starttlsProtocols = map[string]struct{}{
+	"smtp": {},
+	"imap": {},
+	"pop3": {},
+	"ftp":  {},
+	// openssl 1.1.1 or later
+	"postgres": {},
+	"mysql":    {},
+}
+
+// proxyPassEnv is the variable the --proxy-auth password is passed to
+// s_client in, as arguments are visible to every user in the process list
+const proxyPassEnv = "CHECK_CERT_NET_PROXY_PASS"
+
+// sClientEnv returns the environment sClientCommand expects s_client to run with
+func sClientEnv(opts CheckOptions) []string {
+	if opts.ProxyAuth == "" {
+		return nil
+	}
+	pass := ""
+	if i := strings.Index(opts.ProxyAuth, ":"); i >= 0 {
+		pass = opts.ProxyAuth[i+1:]
+	}
+	return []string{proxyPassEnv + "=" + pass}
+}
+
+// sClientCommand builds the openssl s_client command line.
+// The servername is sent exactly as given, its case is never changed.
+func sClientCommand(opts CheckOptions) ([]string, error) {
+	sClientCmd := []string{opts.OpenSSLPath, "s_client"}
+	if opts.ServerName != "" {
+		sClientCmd = append(sClientCmd, "-servername")
+		sClientCmd = append(sClientCmd, opts.ServerName)
+	}
+	if opts.SourceAddr != "" {
+		if err := checkSourceAddr(opts.SourceAddr); err != nil {
+			return nil, err
+		}
+		bind := opts.SourceAddr
+		if strings.Contains(bind, ":") {
+			bind = "[" + bind + "]"
+		}
+		sClientCmd = append(sClientCmd, "-bind")
+		sClientCmd = append(sClientCmd, bind)
+	}
+	if path, ok := unixSocket(opts); ok {
+		if opts.Proxy != "" || opts.SourceAddr != "" {
+			return nil, usageErrorf("--proxy and --source-addr cannot be used with a unix socket")
+		}
+		sClientCmd = append(sClientCmd, "-unix")
+		sClientCmd = append(sClientCmd, path)
+	} else {
+		sClientCmd = append(sClientCmd, "-connect")
+		sClientCmd = append(sClientCmd, net.JoinHostPort(opts.Host, opts.Port))
+	}
+	if opts.ProxyAuth != "" && opts.Proxy == "" {
+		return nil, usageErrorf("--proxy-auth requires --proxy")
+	}
+	if opts.SOCKS5 != "" {
+		return nil, usageErrorf("--socks5 requires --native; openssl s_client cannot connect through SOCKS5")
+	}
+	if opts.Renegotiate != "" {
+		return nil, usageErrorf("--renegotiate requires --native")
+	}
+	if opts.TCPKeepAlive != 0 || opts.TCPNoDelay != "" {
+		return nil, usageErrorf("--tcp-keepalive and --tcp-nodelay require --native; openssl s_client has no socket options")
+	}
+	if opts.Proxy != "" {
+		sClientCmd = append(sClientCmd, "-proxy")
+		sClientCmd = append(sClientCmd, opts.Proxy)
+	}
+	if opts.ProxyAuth != "" {
+		user := opts.ProxyAuth
+		if i := strings.Index(opts.ProxyAuth, ":"); i >= 0 {
+			user = opts.ProxyAuth[:i]
+		}
+		sClientCmd = append(sClientCmd, "-proxy_user")
+		sClientCmd = append(sClientCmd, user)
+		sClientCmd = append(sClientCmd, "-proxy_pass")
+		sClientCmd = append(sClientCmd, "env:"+proxyPassEnv)
+	}
+	if (opts.ClientCert == "") != (opts.ClientKey == "") {
+		return nil, usageErrorf("--client-cert and --client-key must be given together")
+	}
+	if opts.ClientCert != "" {
+		sClientCmd = append(sClientCmd, "-cert")
+		sClientCmd = append(sClientCmd, opts.ClientCert)
+		sClientCmd = append(sClientCmd, "-key")
+		sClientCmd = append(sClientCmd, opts.ClientKey)
+	}
+	if opts.StartTLS != "" {
+		if _, ok := starttlsProtocols[opts.StartTLS]; !ok {
+			return nil, usageErrorf("unsupported --starttls protocol: %s", opts.StartTLS)
+		}
+		sClientCmd = append(sClientCmd, "-starttls")
+		sClientCmd = append(sClientCmd, opts.StartTLS)
+	}
+	if opts.RSA && opts.ECDSA {
+		return nil, usageErrorf("cannot use --rsa and --ecdsa at the same time")
+	}
+	if alg := authAlgorithm(opts); alg != "" {
+		// TLS 1.3 cipher suites do not select the cert by the key,
+		// so -cipher only works with TLS 1.2
+		sClientCmd = append(sClientCmd, "-cipher")
+		sClientCmd = append(sClientCmd, "a"+alg)
+		sClientCmd = append(sClientCmd, "-tls1_2")
+	}
+	if opts.ALPN != "" {
+		sClientCmd = append(sClientCmd, "-alpn")
+		sClientCmd = append(sClientCmd, opts.ALPN)
+	}
+	if needsChain(opts) {
+		sClientCmd = append(sClientCmd, "-showcerts")
+	}
+	if opts.CheckOCSP {
+		sClientCmd = append(sClientCmd, "-status")
+	}
+	if opts.CAFile != "" {
+		sClientCmd = append(sClientCmd, "-CAfile")
+		sClientCmd = append(sClientCmd, opts.CAFile)
+	}
+	return sClientCmd, nil
+}
+
+// shellQuote quotes s for a shell when needed
+func shellQuote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n'\"\\$`|&;<>()*?[]#~") {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+	return s
+}
+
+// debugCommand prints the command line, quoted for a shell, with --debug
+func debugCommand(opts CheckOptions, stdin string, cmd []string) {
+	if !opts.Debug {
+		return
+	}
+	args := make([]string, 0, len(cmd))
+	for _, a := range cmd {
+		args = append(args, shellQuote(a))
+	}
+	line := strings.Join(args, " ")
+	if stdin != "" {
+		line = fmt.Sprintf("echo %s | %s", shellQuote(stdin), line)
+	}
+	fmt.Fprintf(os.Stderr, "+ %s\n", line)
+}
+
+// stageName names a pipeline stage like "openssl s_client"
+func stageName(args []string) string {
+	name := filepath.Base(args[0])
+	if len(args) > 1 && strings.Contains(name, "openssl") {
+		return name + " " + args[1]
+	}
+	return name
+}
+
+func getCertInfo(ctx context.Context, opts CheckOptions) (*certInfo, error) {
+	if _, ok := unixSocket(opts); !ok && opts.CertFile == "" {
+		if err := checkPort(opts.Port); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Native {
+		return getCertInfoNative(ctx, opts)
+	}
+	if _, err := exec.LookPath(opts.OpenSSLPath); err != nil {
+		return nil, usageErrorf("openssl binary %s not found; install openssl or use --native", opts.OpenSSLPath)
+	}
+
+	sClientCmd, err := sClientCommand(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	ch := make(chan certInfo, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		var buf bytes.Buffer
+		var ebuf bytes.Buffer
+		x509Cmd := []string{opts.OpenSSLPath, "x509", "-noout", "-text", "-fingerprint", "-sha256", "-pubkey"}
+		var chain []*certInfo
+		protocol := ""
+		cipher := ""
+		ocspStatus := ""
+		var der []byte
+		verifyError := ""
+		var results []execpipe.Result
+		var err error
+		if opts.CertFile != "" {
+			x509Cmd = append(x509Cmd, "-in", opts.CertFile)
+			debugCommand(opts, "", x509Cmd)
+			results, err = execpipe.CommandWithStatus(ctx, nil, &buf, &ebuf, x509Cmd)
+			if err == nil {
+				var data []byte
+				data, err = ioutil.ReadFile(opts.CertFile)
+				if err == nil {
+					der = firstCertDER(data)
+				}
+				if err == nil && needsChain(opts) {
+					chain, err = parseChain(data)
+				}
+				if err == nil && opts.VerifyChain {
+					verifyError = verifyPEMChain(data, opts.CAFile, opts.currentTime())
+				}
+			}
+		} else {
+			// keep the s_client output to read the session and the chain,
+			// then hand it to openssl x509 which only reads the leaf
+			var raw bytes.Buffer
+			debugCommand(opts, opts.QuitString, sClientCmd)
+			results, err = execpipe.CommandWithStatusEnv(ctx, sClientEnv(opts), strings.NewReader(opts.QuitString+"\n"), &raw, &ebuf, sClientCmd)
+			if err == nil {
+				protocol, cipher = negotiated(raw.Bytes())
+				if opts.CheckOCSP {
+					ocspStatus = stapledOCSPStatus(raw.Bytes())
+				}
+				der = firstCertDER(certSection(raw.Bytes()))
+				if opts.VerifyChain {
+					verifyError = verifyResult(raw.Bytes())
+				}
+				if needsChain(opts) {
+					chain, err = parseChain(certSection(raw.Bytes()))
+				}
+			}
+			if err == nil {
+				debugCommand(opts, "", x509Cmd)
+				results, err = execpipe.CommandWithStatus(ctx, bytes.NewReader(certSection(raw.Bytes())), &buf, &ebuf, x509Cmd)
+			}
+		}
+		if opts.Debug {
+			fmt.Fprintf(os.Stderr, "stderr:\n%s", ebuf.String())
+		}
+		if err != nil {
+			if opts.StartTLS != "" && strings.Contains(ebuf.String(), "Value must be one of") {
+				errCh <- usageErrorf("%s does not support --starttls %s; postgres and mysql require openssl 1.1.1 or later", opts.OpenSSLPath, opts.StartTLS)
+				return
+			}
+			if alg := authAlgorithm(opts); alg != "" && strings.Contains(ebuf.String(), "handshake failure") {
+				errCh <- fmt.Errorf("no %s certificate served: TLS 1.2 handshake with -cipher a%s failed", alg, alg)
+				return
+			}
+			if ce := classifyConnError(ebuf.String()); ce != nil {
+				errCh <- ce
+				return
+			}
+			if r, ok := execpipe.Failed(results); ok && ctx.Err() == nil {
+				// only the stderr of the failed stage, so that s_client
+				// diagnostics are not reported as x509 errors
+				errCh <- fmt.Errorf("%s failed (exit status %d):%s", stageName(r.Args), r.ExitCode, fmtString(string(r.Stderr)))
+				return
+			}
+			errCh <- fmt.Errorf("%s:%s", err, fmtString(ebuf.String()))
+			return
+		}
+		ci, err := parseX509Text(buf.Bytes())
+		if err != nil {
+			// the stderr tells a half completed handshake from a parse miss
+			if ebuf.Len() > 0 {
+				err = fmt.Errorf("%s, stderr:%s", err, fmtString(ebuf.String()))
+			}
+			errCh <- err
+			return
+		}
+		ci.chain = chain
+		ci.protocol = protocol
+		ci.cipher = cipher
+		ci.ocspStatus = ocspStatus
+		ci.der = der
+		ci.verifyError = verifyError
+		ch <- *ci
+	}()
+
+	select {
+	case ci := <-ch:
+		return &ci, nil
+	case err := <-errCh:
+		return nil, err
+	case <-ctx.Done():
+		return nil, &connError{"command timeout"}
+	}
+
+}
+
+// parseX509Text parses the output of openssl x509 -noout -text -fingerprint -sha256 -pubkey
+func parseX509Text(out []byte) (*certInfo, error) {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	subjects := make([]string, 0)
+	commonNames := make([]string, 0)
+	sans := make([]string, 0)
+	ms := make(map[string]struct{})
+	var notAfter *time.Time
+	var notBefore *time.Time
+	sctTimestamps := make([]time.Time, 0)
+	subject := ""
+	issuer := ""
+	serial := ""
+	fingerprint := ""
+	var pubKey bytes.Buffer
+	inPubKey := false
+	keyAlgorithm := ""
+	keyBits := 0
+	curveOID := ""
+	curve := ""
+	sigAlgorithm := ""
+	prev := ""
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if strings.Index(l, "Signature Algorithm: ") == 0 && sigAlgorithm == "" {
+			sigAlgorithm = l[len("Signature Algorithm: "):]
+		}
+		if strings.Index(l, "Public Key Algorithm: ") == 0 {
+			keyAlgorithm = l[len("Public Key Algorithm: "):]
+		}
+		if i := strings.Index(l, "Public-Key: ("); i >= 0 && strings.HasSuffix(l, " bit)") {
+			keyBits, _ = strconv.Atoi(l[i+len("Public-Key: (") : len(l)-len(" bit)")])
+		}
+		if strings.Index(l, "ASN1 OID: ") == 0 {
+			curveOID = l[len("ASN1 OID: "):]
+		}
+		if strings.Index(l, "NIST CURVE: ") == 0 {
+			curve = l[len("NIST CURVE: "):]
+		}
+		if l == "-----BEGIN PUBLIC KEY-----" {
+			inPubKey = true
+		}
+		if inPubKey {
+			pubKey.WriteString(l + "\n")
+		}
+		if l == "-----END PUBLIC KEY-----" {
+			inPubKey = false
+		}
+		if strings.Index(strings.ToLower(l), "sha256 fingerprint=") == 0 {
+			fingerprint = normalizeFingerprint(l[len("sha256 fingerprint="):])
+		}
+		if strings.Index(l, "Serial Number:") == 0 {
+			serial = strings.TrimSpace(l[len("Serial Number:"):])
+		} else if strings.Index(prev, "Serial Number:") == 0 && serial == "" {
+			serial = l
+		}
+		if strings.Index(l, "Issuer: ") == 0 {
+			issuer = l[len("Issuer: "):]
+		}
+		if strings.Index(l, "Subject: ") == 0 {
+			subject = l[len("Subject: "):]
+		}
+		if strings.Index(l, "Subject:") == 0 {
+			// the CN is not always the first attribute, and SAN-only
+			// certs have none at all
+			for _, cn := range dnValues(strings.TrimSpace(l[len("Subject:"):]), "CN") {
+				commonNames = append(commonNames, cn)
+				if _, ok := ms[cn]; !ok {
+					subjects = append(subjects, cn)
+					ms[cn] = struct{}{}
+				}
+			}
+		}
+		if strings.Index(l, "Not After : ") == 0 {
+			na, err := time.Parse(layout, l[len("Not After : "):])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s", err, l)
+			}
+			notAfter = &na
+		}
+		if strings.Index(l, "Not Before: ") == 0 {
+			nb, err := time.Parse(layout, l[len("Not Before: "):])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s", err, l)
+			}
+			notBefore = &nb
+		}
+		if strings.Index(l, "Timestamp : ") == 0 {
+			ts, err := time.Parse(layout, l[len("Timestamp : "):])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s", err, l)
+			}
+			sctTimestamps = append(sctTimestamps, ts)
+		}
+		if strings.Index(prev, "Subject Alternative Name:") >= 0 {
+			// DNS names may follow other names such as "IP Address:"
+			for _, d := range strings.Split(l, ",") {
+				d2 := strings.TrimSpace(d)
+				if strings.Index(d2, "DNS:") == 0 {
+					d3 := strings.TrimSpace(d2[len("DNS:"):])
+					sans = append(sans, d3)
+					if _, ok := ms[d3]; !ok {
+						subjects = append(subjects, d3)
+						ms[d3] = struct{}{}
+					}
+				}
+			}
+		}
+		prev = l
+	}
+	if notAfter == nil {
+		return nil, fmt.Errorf("could not find notAfter in result (%s)", outputTail(out, 3))
+	}
+	if curve == "" {
+		curve = curveName(curveOID)
+	}
+	spkiPin := ""
+	if block, _ := pem.Decode(pubKey.Bytes()); block != nil {
+		sum := sha256.Sum256(block.Bytes)
+		spkiPin = base64.StdEncoding.EncodeToString(sum[:])
+	}
+	return &certInfo{
+		notAfter:      notAfter,
+		notBefore:     notBefore,
+		sctTimestamps: sctTimestamps,
+		subjects:      subjects,
+		commonNames:   commonNames,
+		subject:       subject,
+		sans:          sans,
+		issuer:        issuer,
+		serial:        serial,
+		fingerprint:   fingerprint,
+		spkiPin:       spkiPin,
+		keyAlgorithm:  keyAlgorithm,
+		keyBits:       keyBits,
+		curve:         curve,
+		sigAlgorithm:  sigAlgorithm,
+		selfSigned:    issuer != "" && issuer == subject,
+	}, nil
+}
+
+// curveAliases maps openssl curve OID names to their NIST names
+var curveAliases = map[string]string{
+	"secp224r1":  "P-224",
+	"prime256v1": "P-256",
+	"secp384r1":  "P-384",
+	"secp521r1":  "P-521",
+}
+
+// curveName returns the NIST name of an EC curve when it has one
+func curveName(name string) string {
+	if n, ok := curveAliases[strings.ToLower(name)]; ok {
+		return n
+	}
+	return name
+}
+
+// firstCertDER returns the DER of the first PEM certificate in out
+func firstCertDER(out []byte) []byte {
+	for {
+		var block *pem.Block
+		block, out = pem.Decode(out)
+		if block == nil {
+			return nil
+		}
+		if block.Type == "CERTIFICATE" {
+			return block.Bytes
+		}
+	}
+}
+
+// verifyResult returns the reason printed by s_client when the chain does
+// not verify ("Verify return code: 20 (unable to get local issuer certificate)"),
+// or "" when it does
+func verifyResult(out []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if strings.Index(l, "Verify return code: ") == 0 {
+			code := l[len("Verify return code: "):]
+			if strings.Index(code, "0 ") == 0 {
+				return ""
+			}
+			return code
+		}
+	}
+	return "no verification result"
+}
+
+// certSection skips the s_client output before the certificate chain, where
+// -status prints the OCSP response together with the responder certificate
+func certSection(out []byte) []byte {
+	if i := bytes.Index(out, []byte("\nCertificate chain\n")); i >= 0 {
+		return out[i:]
+	}
+	return out
+}
+
+// negotiated returns the protocol and the cipher of the session. The
+// protocol is read from the "Protocol  :" line of the SSL-Session block as
+// the "New, TLSv1.0, Cipher is ECDHE-RSA-AES128-SHA" line printed after the
+// handshake carries the minimum version of the cipher, not the negotiated
+// one. The "New," line is used only when the block is missing, which
+// happens when the session is closed before a TLS 1.3 session ticket arrives.
+func negotiated(out []byte) (string, string) {
+	var protocol, newProtocol, cipher string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if strings.Index(l, "Protocol  :") == 0 {
+			protocol = strings.TrimSpace(l[len("Protocol  :"):])
+			continue
+		}
+		if strings.Index(l, "New, ") != 0 {
+			continue
+		}
+		parts := strings.Split(l, ", ")
+		if len(parts) == 3 && strings.Index(parts[2], "Cipher is ") == 0 {
+			newProtocol, cipher = parts[1], parts[2][len("Cipher is "):]
+		}
+	}
+	if cipher == "" {
+		return "", ""
+	}
+	if protocol == "" {
+		protocol = newProtocol
+	}
+	return protocol, cipher
+}
+
+// stapledOCSPStatus returns the cert status of the OCSP response printed by
+// s_client -status: good, revoked or unknown. It returns "none" when no
+// response is stapled and the response status when it is not successful.
+func stapledOCSPStatus(out []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "OCSP response: no response sent" {
+			return "none"
+		}
+		if strings.Index(l, "OCSP Response Status: ") == 0 {
+			rs := l[len("OCSP Response Status: "):]
+			if strings.Index(rs, "successful") != 0 {
+				return rs
+			}
+		}
+		if strings.Index(l, "Cert Status: ") == 0 {
+			return l[len("Cert Status: "):]
+		}
+	}
+	return "none"
+}
+
+// tls versions as printed by openssl. TLSv1.0 is the "New," spelling of
+// openssl 1.1 and later, TLSv1/SSLv3 the one of openssl 1.0.2.
+var tlsVersions = map[string]int{
+	"SSLv3":       0,
+	"TLSv1":       1,
+	"TLSv1.0":     1,
+	"TLSv1/SSLv3": 1,
+	"TLSv1.1":     2,
+	"TLSv1.2":     3,
+	"TLSv1.3":     4,
+}
+
+// errDeadline is returned once the --deadline of a check has passed
+var errDeadline = errors.New("overall deadline exceeded")
+
+// maxRetryBackoff caps the doubling of the wait between retries
+const maxRetryBackoff = time.Minute
+
+// retryBackoff returns the wait before the retry-th retry, counted from 0:
+// interval doubled on every retry, but not beyond maxRetryBackoff unless
+// interval itself is longer
+func retryBackoff(interval time.Duration, retry int) time.Duration {
+	if interval <= 0 || interval >= maxRetryBackoff {
+		return interval
+	}
+	for i := 0; i < retry && interval < maxRetryBackoff; i++ {
+		interval *= 2
+	}
+	if interval > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return interval
+}
+
+// getCertInfoWithRetry retries getCertInfo on connection errors and timeouts.
+// Other errors are deterministic and returned at once. Each attempt is cut
+// to the time left before the deadline of ctx if it has one.
+func getCertInfoWithRetry(ctx context.Context, opts CheckOptions) (*certInfo, error) {
+	deadline, _ := ctx.Deadline()
+	for i := 0; ; i++ {
+		o := opts
+		if !deadline.IsZero() {
+			remain := time.Until(deadline)
+			if remain <= 0 {
+				return nil, errDeadline
+			}
+			if remain < o.Timeout {
+				o.Timeout = remain
+			}
+			if remain < o.DNSTimeout {
+				o.DNSTimeout = remain
+			}
+		}
+		cert, err := getCertInfo(ctx, o)
+		if err != nil && !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, errDeadline
+		}
+		if _, ok := err.(*connError); !ok || i >= opts.Retries {
+			return cert, err
+		}
+		interval := retryBackoff(opts.RetryInterval, i)
+		if !deadline.IsZero() && time.Until(deadline) < interval {
+			return nil, errDeadline
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// normalizeFingerprint lowercases a hex fingerprint and strips colons
+func normalizeFingerprint(s string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+}
+
+// certSerial parses the serial of certInfo, either "4096 (0x1000)" or
+// colon separated hex
+func certSerial(serial string) (*big.Int, bool) {
+	if i := strings.Index(serial, " ("); i >= 0 {
+		return new(big.Int).SetString(serial[:i], 10)
+	}
+	return new(big.Int).SetString(strings.Replace(serial, ":", "", -1), 16)
+}
+
+// expectedSerials returns the values an --expected-serial may mean. Digits
+// only are read both as decimal and as hex with the colons left out.
+func expectedSerials(s string) ([]*big.Int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if i := strings.Index(s, " ("); i >= 0 {
+		s = s[:i]
+	}
+	values := make([]*big.Int, 0, 2)
+	if strings.Trim(s, "0123456789") == "" {
+		if v, ok := new(big.Int).SetString(s, 10); ok {
+			values = append(values, v)
+		}
+	}
+	if v, ok := new(big.Int).SetString(strings.Replace(strings.TrimPrefix(s, "0x"), ":", "", -1), 16); ok {
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, usageErrorf("invalid --expected-serial %s: expected decimal or hex", s)
+	}
+	return values, nil
+}
+
+// serialMatches reports whether the serial of cert is one of values
+func serialMatches(serial string, values []*big.Int) bool {
+	v, ok := certSerial(serial)
+	if !ok {
+		return false
+	}
+	for _, e := range values {
+		if v.Cmp(e) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dnAttr is one attribute of a DN
+type dnAttr struct {
+	typ   string
+	value string
+}
+
+// parseDN splits a one-line DN into its attributes in order.
+// Both "CN=foo, O=bar" and "CN = foo, O = bar" forms are accepted, as well as
+// multi-valued RDNs joined with "+" and commas escaped with a backslash.
+func parseDN(dn string) []dnAttr {
+	attrs := make([]dnAttr, 0)
+	var buf strings.Builder
+	flush := func() {
+		kv := strings.SplitN(buf.String(), "=", 2)
+		buf.Reset()
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return
+		}
+		attrs = append(attrs, dnAttr{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+	}
+	quoted := false
+	for i := 0; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == '\\' && i+1 < len(dn):
+			i++
+			buf.WriteByte(dn[i])
+		case c == '"':
+			quoted = !quoted
+		case (c == ',' || c == '+') && !quoted:
+			flush()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return attrs
+}
+
+// dnValues returns the values of the named attribute in a one-line DN
+func dnValues(dn, name string) []string {
+	values := make([]string, 0)
+	for _, attr := range parseDN(dn) {
+		if attr.typ == name {
+			values = append(values, attr.value)
+		}
+	}
+	return values
+}
+
+// deprecatedSubjectAttributes are Subject attribute types the CA/Browser Forum
+// Baseline Requirements no longer allow in server certs, by openssl name
+var deprecatedSubjectAttributes = []string{
+	"emailAddress",        // 1.2.840.113549.1.9.1
+	"unstructuredName",    // 1.2.840.113549.1.9.2
+	"unstructuredAddress", // 1.2.840.113549.1.9.8
+	"OU",                  // 2.5.4.11, prohibited since September 2022
+}
+
+// deprecatedSubjectPractices returns legacy Subject practices the cert relies on
+func deprecatedSubjectPractices(cert *certInfo) []string {
+	practices := make([]string, 0)
+	if len(dnValues(cert.subject, "CN")) > 0 && len(cert.sans) == 0 {
+		practices = append(practices, "CN used as hostname without subjectAltName")
+	}
+	for _, typ := range deprecatedSubjectAttributes {
+		if len(dnValues(cert.subject, typ)) > 0 {
+			practices = append(practices, typ+" in subject")
+		}
+	}
+	return practices
+}
+
+// readIssuerAllowlist reads acceptable issuers from an @file, skipping blanks and comments
+func readIssuerAllowlist(name string) ([]string, error) {
+	b, err := ioutil.ReadFile(strings.TrimPrefix(name, "@"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read issuer allowlist: %s", err)
+	}
+	issuers := make([]string, 0)
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.Index(l, "#") == 0 {
+			continue
+		}
+		issuers = append(issuers, l)
+	}
+	return issuers, nil
+}
+
+// issuerAllowed reports whether the issuer CN or O matches one of allowlist
+func issuerAllowed(issuer string, allowlist []string) bool {
+	names := append(dnValues(issuer, "CN"), dnValues(issuer, "O")...)
+	for _, a := range allowlist {
+		for _, n := range names {
+			if n == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// currentTime returns the time validity is computed at: opts.Clock, set
+// from --now, or the current time
+func (opts CheckOptions) currentTime() time.Time {
+	if opts.Clock != nil {
+		return opts.Clock()
+	}
+	return time.Now()
+}
+
+// daysRemaining returns the number of whole days until the cert expires
+func daysRemaining(opts CheckOptions, cert *certInfo) int64 {
+	return int64(cert.notAfter.Sub(opts.currentTime().UTC()).Hours() / 24)
+}
+
+// matchHostname reports whether the cert name pattern covers serverName per RFC 6125.
+// A wildcard must be the whole leftmost label, matches exactly one label and
+// must not cover a public suffix such as *.com or *.co.uk.
+func matchHostname(pattern, serverName string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if strings.Index(pattern, "*.") != 0 {
+		return pattern == serverName
+	}
+	parent := pattern[len("*."):]
+	if strings.Contains(parent, "*") {
+		return false
+	}
+	if ps, _ := publicsuffix.PublicSuffix(parent); ps == parent {
+		return false
+	}
+	labels := strings.Split(serverName, ".")
+	if labels[0] == "" || len(labels) != len(strings.Split(pattern, ".")) {
+		return false
+	}
+	return strings.Join(labels[1:], ".") == parent
+}
+
+// verifyServerName reports whether serverName is covered by subjects.
+// Names are compared case-insensitively.
+func verifyServerName(subjects []string, serverName string) bool {
+	for _, subject := range subjects {
+		if matchHostname(subject, serverName) {
+			return true
+		}
+	}
+	return false
+}
+
+// servernameMismatch describes a servername not covered by the cert
+func servernameMismatch(serverName string, subjects []string) string {
+	if len(subjects) == 0 {
+		return fmt.Sprintf("servername:%s cannot be verified, the cert has neither CN nor DNS SAN", serverName)
+	}
+	return fmt.Sprintf("servername:%s is not included in %s", serverName, strings.Join(subjects, ","))
+}
+
+// sniMismatch describes a cert whose DNS SANs do not cover the SNI sent,
+// which usually means the server returned its default vhost
+func sniMismatch(sni string, cert *certInfo) string {
+	if len(cert.sans) == 0 {
+		return fmt.Sprintf("SNI %s is not covered, the returned cert %s has no DNS SAN; the server may have returned its default vhost", sni, cert.subject)
+	}
+	return fmt.Sprintf("SNI %s is not covered by the returned cert for %s (%s); the server may have returned its default vhost", sni, strings.Join(cert.subjects, ","), cert.subject)
+}
+
+// sanOrderIssues returns duplicated and out of order entries in the served SANs
+func sanOrderIssues(sans []string) []string {
+	issues := make([]string, 0)
+	seen := make(map[string]struct{})
+	for i, san := range sans {
+		d := strings.ToLower(san)
+		if _, ok := seen[d]; ok {
+			issues = append(issues, fmt.Sprintf("duplicate SAN %s", san))
+		}
+		seen[d] = struct{}{}
+		if i > 0 && d < strings.ToLower(sans[i-1]) {
+			issues = append(issues, fmt.Sprintf("SAN %s is out of order after %s", san, sans[i-1]))
+		}
+	}
+	return issues
+}
+
+// sanAuditIssues returns odd SAN configurations: IP addresses in DNS SANs,
+// duplicated SANs or SANs already covered by a wildcard, and CNs the SANs
+// do not cover, which browsers ignore
+func sanAuditIssues(cert *certInfo) []string {
+	issues := make([]string, 0)
+	sans := make([]string, 0, len(cert.sans))
+	seen := make(map[string]struct{})
+	for _, san := range cert.sans {
+		d := strings.ToLower(san)
+		if _, ok := seen[d]; ok {
+			issues = append(issues, fmt.Sprintf("duplicate SAN %s", san))
+			continue
+		}
+		seen[d] = struct{}{}
+		sans = append(sans, san)
+		if net.ParseIP(san) != nil {
+			issues = append(issues, fmt.Sprintf("IP address %s in a DNS SAN", san))
+		}
+	}
+	for _, wild := range sans {
+		if strings.Index(wild, "*.") != 0 {
+			continue
+		}
+		for _, san := range sans {
+			if strings.Index(san, "*.") != 0 && matchHostname(wild, san) {
+				issues = append(issues, fmt.Sprintf("SAN %s is already covered by %s", san, wild))
+			}
+		}
+	}
+	for _, cn := range cert.commonNames {
+		if !verifyServerName(cert.sans, cn) {
+			issues = append(issues, fmt.Sprintf("CN %s is not in the SANs", cn))
+		}
+	}
+	return issues
+}
+
+// staleSCTs returns SCT timestamps further than within from notBefore
+func staleSCTs(cert *certInfo, within time.Duration) []string {
+	stale := make([]string, 0)
+	if cert.notBefore == nil {
+		return stale
+	}
+	for _, ts := range cert.sctTimestamps {
+		d := ts.Sub(*cert.notBefore)
+		if d < 0 {
+			d = -d
+		}
+		if d > within {
+			stale = append(stale, ts.UTC().Format(time.RFC3339))
+		}
+	}
+	return stale
+}
+
+// templateData is the data passed to --template
+type templateData struct {
+	NotAfter      time.Time
+	NotBefore     time.Time
+	DaysRemaining int64
+	Subjects      []string
+	Subject       string
+	Issuer        string
+	Serial        string
+	Status        string
+	Message       string
+}
+
+type fieldMatcher struct {
+	field string
+	re    *regexp.Regexp
+}
+
+// parseFieldMatchers parses --match-field field=regex pairs
+func parseFieldMatchers(specs []string) ([]fieldMatcher, error) {
+	matchers := make([]fieldMatcher, 0, len(specs))
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --match-field %s: expected field=regex", spec)
+		}
+		switch kv[0] {
+		case "issuer", "subject", "san", "serial":
+		default:
+			return nil, fmt.Errorf("invalid --match-field %s: unknown field %s", spec, kv[0])
+		}
+		re, err := regexp.Compile(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match-field %s: %s", spec, err)
+		}
+		matchers = append(matchers, fieldMatcher{kv[0], re})
+	}
+	return matchers, nil
+}
+
+// values returns the values of the field in cert
+func (m fieldMatcher) values(cert *certInfo) []string {
+	switch m.field {
+	case "issuer":
+		return []string{cert.issuer}
+	case "subject":
+		return []string{cert.subject}
+	case "serial":
+		return []string{cert.serial}
+	}
+	return cert.sans
+}
+
+// match reports whether the field of cert matches. san matches when any SAN does.
+func (m fieldMatcher) match(cert *certInfo) bool {
+	for _, v := range m.values(cert) {
+		if m.re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindow parses a START,END pair of RFC3339 times
+func parseWindow(s string) (time.Time, time.Time, error) {
+	se := strings.SplitN(s, ",", 2)
+	if len(se) != 2 {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --no-expiry-between %s: expected START,END", s)
+	}
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(se[0]))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --no-expiry-between: %s", err)
+	}
+	end, err := time.Parse(time.RFC3339, strings.TrimSpace(se[1]))
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --no-expiry-between: %s", err)
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid --no-expiry-between %s: END is before START", s)
+	}
+	return start, end, nil
+}
+
+// parseDate parses an RFC3339 time or a 2006-01-02 date in UTC
+func parseDate(name, s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %s: expected RFC3339 or 2006-01-02", name, s)
+	}
+	return t, nil
+}
+
+// isEmergency reports whether the cert is within the --emergency threshold
+func isEmergency(opts CheckOptions, cert *certInfo) bool {
+	return opts.Emergency > 0 && cert.notAfter.Sub(opts.currentTime()) < time.Duration(opts.Emergency)
+}
+
+// checkConfig runs the option and file validation of checkCertNet and
+// getCertInfo without connecting or resolving any name
+func checkConfig(opts CheckOptions) error {
+	if _, err := time.LoadLocation(opts.Timezone); err != nil {
+		return fmt.Errorf("invalid --timezone: %s", err)
+	}
+	if opts.Template != "" {
+		if _, err := template.New("message").Funcs(template.FuncMap{"join": strings.Join}).Parse(opts.Template); err != nil {
+			return fmt.Errorf("invalid --template: %s", err)
+		}
+	}
+	if opts.IssuerAllowlist != "" {
+		if _, err := readIssuerAllowlist(opts.IssuerAllowlist); err != nil {
+			return err
+		}
+	}
+	if opts.NoExpiryBetween != "" {
+		if _, _, err := parseWindow(opts.NoExpiryBetween); err != nil {
+			return err
+		}
+	}
+	if opts.CriticalBefore != "" {
+		if _, err := parseDate("--critical-before", opts.CriticalBefore); err != nil {
+			return err
+		}
+	}
+	if opts.WarningBefore != "" {
+		if _, err := parseDate("--warning-before", opts.WarningBefore); err != nil {
+			return err
+		}
+	}
+	if _, err := parseFieldMatchers(opts.MatchField); err != nil {
+		return err
+	}
+	if opts.ExpectedSerial != "" {
+		if _, err := expectedSerials(opts.ExpectedSerial); err != nil {
+			return err
+		}
+	}
+	if opts.Targets != "" || opts.TargetsFile != "" || strings.Contains(opts.Port, ",") {
+		targets, err := parseTargets(opts)
+		if err != nil {
+			return err
+		}
+		for _, o := range targets {
+			if err := checkPort(o.Port); err != nil {
+				return err
+			}
+		}
+	} else if _, ok := unixSocket(opts); !ok && opts.CertFile == "" && opts.SRV == "" {
+		if err := checkPort(opts.Port); err != nil {
+			return err
+		}
+	}
+	if opts.Both && (opts.RSA || opts.ECDSA) {
+		return usageErrorf("cannot use --both with --rsa or --ecdsa")
+	}
+	if opts.Watch && opts.Interval <= 0 {
+		return usageErrorf("--interval must be positive")
+	}
+	if err := checkInputFiles(opts); err != nil {
+		return err
+	}
+	if opts.Native {
+		if err := checkNativeOpts(opts); err != nil {
+			return err
+		}
+		if opts.SourceAddr != "" {
+			if err := checkSourceAddr(opts.SourceAddr); err != nil {
+				return err
+			}
+		}
+	} else {
+		if _, err := exec.LookPath(opts.OpenSSLPath); err != nil {
+			return usageErrorf("openssl binary %s not found; install openssl or use --native", opts.OpenSSLPath)
+		}
+		if _, err := sClientCommand(opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkInputFiles checks that the files named by the options can be read,
+// so that a missing file is a usage error and not a failed certificate
+func checkInputFiles(opts CheckOptions) error {
+	for _, f := range []struct {
+		name string
+		path string
+	}{
+		{"--cert-file", opts.CertFile},
+		{"--ca-file", opts.CAFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := ioutil.ReadFile(f.path); err != nil {
+			return usageErrorf("could not read %s: %s", f.name, err)
+		}
+	}
+	if opts.ClientCert != "" && opts.ClientKey != "" {
+		if _, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey); err != nil {
+			return usageErrorf("could not load client certificate: %s", err)
+		}
+	}
+	return nil
+}
+
+// withDeadline bounds ctx by --deadline, so that the DNS lookups as well as
+// the connection attempts stop when it runs out
+func withDeadline(ctx context.Context, opts CheckOptions) (context.Context, context.CancelFunc) {
+	if opts.Deadline > 0 {
+		return context.WithTimeout(ctx, opts.Deadline)
+	}
+	return context.WithCancel(ctx)
+}
+
+// connectionOptions applies --test-name and --resolve-cname to opts, and
+// returns them with the options to connect with and the CNAME chain
+// followed. opts.Host stays the name asked for, which the TLSA records are
+// of; only the connection goes to --sni and the address of the CNAME target.
+func connectionOptions(ctx context.Context, opts CheckOptions) (CheckOptions, CheckOptions, []string, error) {
+	if opts.TestName != "" {
+		opts.ServerName = opts.TestName
+		opts.VerifyServerName = true
+	}
+	var cnameChain []string
+	if opts.ResolveCNAME {
+		var err error
+		cnameChain, err = resolveCNAME(ctx, opts.Host, nameserver(), opts.Timeout)
+		if err != nil {
+			return opts, opts, nil, err
+		}
+		if opts.ServerName == "" {
+			opts.ServerName = opts.Host
+		}
+	}
+	connOpts := opts
+	if opts.SNI != "" {
+		connOpts.ServerName = opts.SNI
+	}
+	if cnameChain != nil {
+		connOpts.Host = cnameChain[len(cnameChain)-1]
+	}
+	return opts, connOpts, cnameChain, nil
+}
+
+// fetchCert fetches the cert as checkCertNet connects, for the modes that
+// only print a value of it
+func fetchCert(ctx context.Context, opts CheckOptions) (*certInfo, error) {
+	ctx, cancel := withDeadline(ctx, opts)
+	defer cancel()
+	_, connOpts, _, err := connectionOptions(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return getCertInfoWithRetry(ctx, connOpts)
+}
+
+// fetchErrorChecker reports an error of getCertInfoWithRetry. Connection
+// failures get --refused-severity or --timeout-severity, usage errors and
+// the deadline UNKNOWN and anything else CRITICAL.
+func fetchErrorChecker(opts CheckOptions, err error) *checkers.Checker {
+	if err == errDeadline {
+		return checkers.Unknown(fmt.Sprintf("%s (%s)", err, opts.Deadline))
+	}
+	if ce, ok := err.(*connError); ok {
+		severity := opts.TimeoutSeverity
+		if ce.reason == "connection refused" {
+			severity = opts.RefusedSeverity
+		}
+		msg := fmt.Sprintf("%s (%s)", ce, endpoint(opts))
+		if opts.Retries > 0 {
+			msg += fmt.Sprintf(" after %d attempts", opts.Retries+1)
+		}
+		return checkers.NewChecker(severities[severity], msg)
+	}
+	if _, ok := err.(*usageError); ok {
+		return checkers.Unknown(err.Error())
+	}
+	return checkers.Critical(err.Error())
+}
+
+func checkCertNet(ctx context.Context, opts CheckOptions) (*checkers.Checker, *certInfo) {
+	ctx, cancel := withDeadline(ctx, opts)
+	defer cancel()
+
+	loc, err := time.LoadLocation(opts.Timezone)
+	if err != nil {
+		return checkers.Unknown(fmt.Sprintf("invalid --timezone: %s", err)), nil
+	}
+
+	opts, connOpts, cnameChain, err := connectionOptions(ctx, opts)
+	if err != nil {
+		return checkers.Unknown(err.Error()), nil
+	}
+
+	var tmpl *template.Template
+	if opts.Template != "" {
+		tmpl, err = template.New("message").Funcs(template.FuncMap{"join": strings.Join}).Parse(opts.Template)
+		if err != nil {
+			return checkers.Unknown(fmt.Sprintf("invalid --template: %s", err)), nil
+		}
+	}
+
+	var allowlist []string
+	if opts.IssuerAllowlist != "" {
+		allowlist, err = readIssuerAllowlist(opts.IssuerAllowlist)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+	}
+
+	var windowStart, windowEnd time.Time
+	if opts.NoExpiryBetween != "" {
+		windowStart, windowEnd, err = parseWindow(opts.NoExpiryBetween)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+	}
+
+	var criticalBefore, warningBefore time.Time
+	if opts.CriticalBefore != "" {
+		criticalBefore, err = parseDate("--critical-before", opts.CriticalBefore)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+	}
+	if opts.WarningBefore != "" {
+		warningBefore, err = parseDate("--warning-before", opts.WarningBefore)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+	}
+
+	matchers, err := parseFieldMatchers(opts.MatchField)
+	if err != nil {
+		return checkers.Unknown(err.Error()), nil
+	}
+
+	var serials []*big.Int
+	if opts.ExpectedSerial != "" {
+		serials, err = expectedSerials(opts.ExpectedSerial)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+	}
+
+	var pins []string
+	if opts.PinFromTXT != "" {
+		pins, err = lookupTXTPins(ctx, opts.PinFromTXT)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+	}
+
+	var tlsaRecords []tlsaRecord
+	if opts.Dane {
+		name := tlsaName(opts.Host, opts.Port)
+		tlsaRecords, err = lookupTLSA(ctx, name, nameserver(), opts.Timeout)
+		if err != nil {
+			return checkers.Unknown(err.Error()), nil
+		}
+		if len(tlsaRecords) == 0 {
+			return checkers.Critical(fmt.Sprintf("no TLSA records found for %s", name)), nil
+		}
+	}
+
+	cert, err := getCertInfoWithRetry(ctx, connOpts)
+	if err != nil {
+		return fetchErrorChecker(opts, err), nil
+	}
+
+	if alg := authAlgorithm(opts); alg != "" && opts.CertFile == "" && !keyMatches(alg, cert.keyAlgorithm) {
+		return checkers.Critical(fmt.Sprintf("no %s certificate served: got a %s key", alg, cert.keyAlgorithm)), cert
+	}
+
+	if opts.MinRSABits > 0 && cert.keyAlgorithm == "rsaEncryption" && cert.keyBits < opts.MinRSABits {
+		return checkers.Critical(fmt.Sprintf("RSA key size %d bit is smaller than %d bit", cert.keyBits, opts.MinRSABits)), cert
+	}
+
+	if opts.RejectCurves != "" && cert.curve != "" {
+		for _, c := range strings.Split(opts.RejectCurves, ",") {
+			if strings.EqualFold(curveName(strings.TrimSpace(c)), cert.curve) {
+				return checkers.Critical(fmt.Sprintf("EC curve %s is rejected", cert.curve)), cert
+			}
+		}
+	}
+
+	if opts.Fingerprint != "" && normalizeFingerprint(opts.Fingerprint) != cert.fingerprint {
+		return checkers.Critical(fmt.Sprintf("fingerprint:%s does not match %s", cert.fingerprint, normalizeFingerprint(opts.Fingerprint))), cert
+	}
+
+	if serials != nil && !serialMatches(cert.serial, serials) {
+		return checkers.Critical(fmt.Sprintf("serial:%s does not match %s", cert.serial, opts.ExpectedSerial)), cert
+	}
+
+	if pins != nil {
+		pinned := false
+		for _, pin := range pins {
+			if pin == cert.fingerprint {
+				pinned = true
+				break
+			}
+		}
+		if !pinned {
+			return checkers.Critical(fmt.Sprintf("fingerprint:%s does not match TXT records of %s", cert.fingerprint, opts.PinFromTXT)), cert
+		}
+	}
+
+	if tlsaRecords != nil && !daneMatches(tlsaRecords, cert) {
+		return checkers.Critical(fmt.Sprintf("cert does not match the TLSA records of %s", tlsaName(opts.Host, opts.Port))), cert
+	}
+
+	if cert.notBefore != nil && opts.currentTime().Before(*cert.notBefore) {
+		return checkers.Critical(fmt.Sprintf("certificate not yet valid (valid from %s)", cert.notBefore.In(loc).Format(opts.DateFormat))), cert
+	}
+
+	for _, m := range matchers {
+		if !m.match(cert) {
+			return checkers.Critical(fmt.Sprintf("%s:%s does not match %s", m.field, strings.Join(m.values(cert), ","), m.re)), cert
+		}
+	}
+
+	if opts.ExpectedIssuer != "" && !strings.Contains(cert.issuer, opts.ExpectedIssuer) {
+		return checkers.Critical(fmt.Sprintf("issuer:%s does not contain %s", cert.issuer, opts.ExpectedIssuer)), cert
+	}
+
+	if allowlist != nil && !issuerAllowed(cert.issuer, allowlist) {
+		return checkers.Critical(fmt.Sprintf("issuer:%s is not in the issuer allowlist", cert.issuer)), cert
+	}
+
+	if opts.VerifyServerName || opts.VerifyName != "" {
+		serverName := opts.ServerName
+		if serverName == "" {
+			serverName = opts.Host
+		}
+		if opts.VerifyName != "" {
+			serverName = opts.VerifyName
+		}
+		if !verifyServerName(cert.subjects, serverName) {
+			return checkers.Critical(servernameMismatch(serverName, cert.subjects)), cert
+		}
+	}
+
+	if opts.StrictSNI && connOpts.ServerName != "" && !verifyServerName(cert.sans, connOpts.ServerName) {
+		return checkers.Critical(sniMismatch(connOpts.ServerName, cert)), cert
+	}
+
+	if opts.MinTLSVersion != "" && cert.protocol != "" {
+		min := "TLSv" + strings.TrimSuffix(opts.MinTLSVersion, ".0")
+		if v, ok := tlsVersions[cert.protocol]; !ok || v < tlsVersions[min] {
+			return checkers.Critical(fmt.Sprintf("negotiated %s is older than %s", cert.protocol, min)), cert
+		}
+	}
+
+	if opts.VerifyChain && cert.verifyError != "" {
+		return checkers.Critical(fmt.Sprintf("chain verification failed: %s", cert.verifyError)), cert
+	}
+
+	if opts.CheckOCSP && cert.ocspStatus == "revoked" {
+		return checkers.Critical("certificate is revoked according to the stapled OCSP response"), cert
+	}
+
+	if opts.CompleteChain {
+		gap, err := chainGap(cert, opts.CAFile, opts.currentTime())
+		if err != nil {
+			return checkers.Critical(fmt.Sprintf("could not verify the chain for --require-complete-chain: %s", err)), cert
+		}
+		if gap != "" {
+			return checkers.Critical(fmt.Sprintf("chain is incomplete: %s", gap)), cert
+		}
+	}
+
+	var root *certInfo
+	if opts.RequireRoot != "" {
+		roots, err := verifiedRoots(cert, opts.CAFile, opts.currentTime())
+		if err != nil {
+			return checkers.Critical(fmt.Sprintf("could not verify the chain for --require-root: %s", err)), cert
+		}
+		for _, r := range roots {
+			if rootMatches(r, opts.RequireRoot) {
+				root = r
+				break
+			}
+		}
+		if root == nil {
+			return checkers.Critical(fmt.Sprintf("chain anchors to %s (sha256 %s), not %s", roots[0].subject, roots[0].fingerprint, opts.RequireRoot)), cert
+		}
+	}
+
+	daysRemain := daysRemaining(opts, cert)
+	msg := fmt.Sprintf("Expiration date: %s, %d days remaining", cert.notAfter.In(loc).Format(opts.DateFormat), daysRemain)
+	if cert.protocol != "" {
+		msg += fmt.Sprintf(", protocol: %s", cert.protocol)
+	}
+	if cert.curve != "" {
+		msg += fmt.Sprintf(", curve: %s", cert.curve)
+	}
+	if opts.ShowCipher && cert.cipher != "" {
+		msg += fmt.Sprintf(", cipher: %s", cert.cipher)
+	}
+
+	if opts.TestName != "" {
+		msg += fmt.Sprintf(", tested name: %s", opts.TestName)
+	}
+	if cnameChain != nil {
+		msg += fmt.Sprintf(", resolved: %s", strings.Join(cnameChain, " -> "))
+	}
+	if root != nil {
+		msg += fmt.Sprintf(", root: %s", root.subject)
+	}
+
+	status := checkers.OK
+	if isEmergency(opts, cert) {
+		status = checkers.CRITICAL
+		msg = "EMERGENCY: " + msg
+	} else if remain := cert.notAfter.Sub(opts.currentTime()); remain < time.Duration(opts.Crit) {
+		status = checkers.CRITICAL
+	} else if remain < time.Duration(opts.Warn) {
+		status = checkers.WARNING
+	}
+
+	if opts.NoExpiryBetween != "" && !cert.notAfter.Before(windowStart) && !cert.notAfter.After(windowEnd) {
+		status = checkers.CRITICAL
+		msg += fmt.Sprintf(", expires within no-expiry window %s - %s", windowStart.In(loc).Format(opts.DateFormat), windowEnd.In(loc).Format(opts.DateFormat))
+	}
+
+	if opts.CheckChain {
+		// the chain has thresholds of its own, so check the soonest
+		// intermediate even when the leaf expires first. They are
+		// pointers as an explicit 0 disables them rather than falling back.
+		crit, warn := opts.Crit, opts.Warn
+		if opts.ChainCrit != nil {
+			crit = *opts.ChainCrit
+		}
+		if opts.ChainWarn != nil {
+			warn = *opts.ChainWarn
+		}
+		soonest := soonestInChain(cert)
+		msg += fmt.Sprintf(", soonest expiry in chain: %s (%s, %d days remaining)", soonest.subject, soonest.notAfter.In(loc).Format(opts.DateFormat), daysRemaining(opts, soonest))
+		if c := soonestIntermediate(cert); c != nil {
+			chainStatus := checkers.OK
+			if remain := c.notAfter.Sub(opts.currentTime()); remain < time.Duration(crit) {
+				chainStatus = checkers.CRITICAL
+			} else if remain < time.Duration(warn) {
+				chainStatus = checkers.WARNING
+			}
+			status = worseStatus(status, chainStatus)
+			if chainStatus != checkers.OK && c != soonest {
+				msg += fmt.Sprintf(", intermediate expiring: %s (%s, %d days remaining)", c.subject, c.notAfter.In(loc).Format(opts.DateFormat), daysRemaining(opts, c))
+			}
+		}
+	}
+
+	if opts.LeafInIssuer {
+		if issuer := issuerInChain(cert); issuer != nil && cert.notAfter.After(*issuer.notAfter) {
+			status = worseStatus(status, checkers.WARNING)
+			msg += fmt.Sprintf(", leaf outlives its issuer: leaf expires %s, %s expires %s", cert.notAfter.In(loc).Format(opts.DateFormat), issuer.subject, issuer.notAfter.In(loc).Format(opts.DateFormat))
+		}
+	}
+
+	if !criticalBefore.IsZero() && cert.notAfter.Before(criticalBefore) {
+		status = checkers.CRITICAL
+		msg += fmt.Sprintf(", expires before %s", criticalBefore.In(loc).Format(opts.DateFormat))
+	} else if !warningBefore.IsZero() && cert.notAfter.Before(warningBefore) {
+		status = worseStatus(status, checkers.WARNING)
+		msg += fmt.Sprintf(", expires before %s", warningBefore.In(loc).Format(opts.DateFormat))
+	}
+
+	if opts.CheckOCSP && cert.ocspStatus != "" && cert.ocspStatus != "good" {
+		status = worseStatus(status, checkers.WARNING)
+		if cert.ocspStatus == "none" {
+			msg += ", no OCSP response stapled"
+		} else {
+			msg += fmt.Sprintf(", stapled OCSP status: %s", cert.ocspStatus)
+		}
+	}
+
+	if opts.RequireValidFor > 0 && cert.notAfter.Before(opts.currentTime().UTC().Add(opts.RequireValidFor)) {
+		status = checkers.CRITICAL
+		msg += fmt.Sprintf(", not valid for required %s", opts.RequireValidFor)
+	}
+
+	if cert.selfSigned && opts.SelfSigned != "ok" {
+		status = worseStatus(status, severities[opts.SelfSigned])
+		msg += ", self-signed certificate presented"
+	}
+
+	if opts.RejectSigAlgs != "" {
+		for _, alg := range strings.Split(opts.RejectSigAlgs, ",") {
+			if strings.EqualFold(strings.TrimSpace(alg), cert.sigAlgorithm) {
+				status = worseStatus(status, severities[opts.SigAlgSeverity])
+				msg += fmt.Sprintf(", rejected signature algorithm %s", cert.sigAlgorithm)
+				break
+			}
+		}
+	}
+
+	if opts.SHA1InChain {
+		for _, c := range sha1Signed(cert) {
+			status = worseStatus(status, severities[opts.SigAlgSeverity])
+			msg += fmt.Sprintf(", SHA-1 signature in chain: %s (%s)", c.subject, c.sigAlgorithm)
+		}
+	}
+
+	if (opts.SANCountMin > 0 && len(cert.sans) < opts.SANCountMin) || (opts.SANCountMax > 0 && len(cert.sans) > opts.SANCountMax) {
+		status = worseStatus(status, checkers.WARNING)
+		msg += fmt.Sprintf(", SAN count %d is out of range", len(cert.sans))
+	}
+
+	if opts.SCTWithin > 0 {
+		if stale := staleSCTs(cert, opts.SCTWithin); len(cert.sctTimestamps) == 0 || len(stale) > 0 {
+			status = worseStatus(status, checkers.WARNING)
+			if len(cert.sctTimestamps) == 0 {
+				msg += ", no embedded SCTs"
+			} else {
+				msg += fmt.Sprintf(", SCT timestamps further than %s from notBefore: %s", opts.SCTWithin, strings.Join(stale, ","))
+			}
+		}
+	}
+
+	if opts.RequireSortedSAN {
+		if issues := sanOrderIssues(cert.sans); len(issues) > 0 {
+			status = worseStatus(status, checkers.WARNING)
+			msg += ", " + strings.Join(issues, ", ")
+		}
+	}
+
+	if opts.AuditSANs {
+		if issues := sanAuditIssues(cert); len(issues) > 0 {
+			status = worseStatus(status, checkers.WARNING)
+			msg += ", " + strings.Join(issues, ", ")
+		}
+	}
+
+	if opts.RequireCNMatch {
+		name := opts.ServerName
+		if name == "" {
+			name = opts.Host
+		}
+		if opts.VerifyName != "" {
+			name = opts.VerifyName
+		}
+		if !verifyServerName(cert.commonNames, name) {
+			status = worseStatus(status, checkers.WARNING)
+			msg += fmt.Sprintf(", servername:%s is not in the CN %s", name, strings.Join(cert.commonNames, ","))
+		}
+	}
+
+	if opts.StrictSubject {
+		if practices := deprecatedSubjectPractices(cert); len(practices) > 0 {
+			status = worseStatus(status, checkers.WARNING)
+			msg += fmt.Sprintf(", deprecated subject practice: %s", strings.Join(practices, ", "))
+		}
+	}
+
+	if tmpl != nil {
+		data := templateData{
+			NotAfter:      cert.notAfter.In(loc),
+			DaysRemaining: daysRemain,
+			Subjects:      cert.subjects,
+			Subject:       cert.subject,
+			Issuer:        cert.issuer,
+			Serial:        cert.serial,
+			Status:        status.String(),
+			Message:       msg,
+		}
+		if cert.notBefore != nil {
+			data.NotBefore = cert.notBefore.In(loc)
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return checkers.Unknown(fmt.Sprintf("could not render --template: %s", err)), cert
+		}
+		msg = buf.String()
+	}
+
+	return checkers.NewChecker(status, msg), cert
+}
+
+// worseStatus returns the more severe of a and b.
+// CRITICAL is treated as worse than UNKNOWN.
+func worseStatus(a, b checkers.Status) checkers.Status {
+	rank := map[checkers.Status]int{
+		checkers.OK:       0,
+		checkers.WARNING:  1,
+		checkers.UNKNOWN:  2,
+		checkers.CRITICAL: 3,
+	}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// checkTarget checks a single target with opts.checker
+func checkTarget(ctx context.Context, opts CheckOptions) (*checkers.Checker, *certInfo) {
+	if opts.checker != nil {
+		return opts.checker(ctx, opts)
+	}
+	return checkCertNet(ctx, opts)
+}
+
+// checkBoth checks the certs served for aRSA and aECDSA ciphers concurrently
+// and reports expiry and servername verification of each
+func checkBoth(ctx context.Context, opts CheckOptions) (*checkers.Checker, []targetResult) {
+	if opts.RSA || opts.ECDSA {
+		return checkers.Unknown("cannot use --both with --rsa or --ecdsa"), nil
+	}
+	verify := opts.VerifyServerName || opts.TestName != "" || opts.VerifyName != ""
+	serverName := opts.ServerName
+	if opts.TestName != "" {
+		serverName = opts.TestName
+	}
+	verifyName := serverName
+	if verifyName == "" {
+		verifyName = opts.Host
+	}
+	if opts.VerifyName != "" {
+		verifyName = opts.VerifyName
+	}
+
+	algs := []string{"RSA", "ECDSA"}
+	ckrs := make([]*checkers.Checker, len(algs))
+	certs := make([]*certInfo, len(algs))
+	var wg sync.WaitGroup
+	for i, alg := range algs {
+		o := opts
+		o.Both = false
+		o.RSA = alg == "RSA"
+		o.ECDSA = alg == "ECDSA"
+		o.ServerName = serverName
+		o.TestName = ""
+		o.VerifyServerName = false
+		o.VerifyName = ""
+		wg.Add(1)
+		go func(i int, o CheckOptions) {
+			defer wg.Done()
+			ckrs[i], certs[i] = checkTarget(ctx, o)
+		}(i, o)
+	}
+	wg.Wait()
+
+	status := checkers.OK
+	msgs := make([]string, 0, 4)
+	results := make([]targetResult, 0, len(algs))
+	for i, alg := range algs {
+		ckr, cert := ckrs[i], certs[i]
+		status = worseStatus(status, ckr.Status)
+		msgs = append(msgs, fmt.Sprintf("%s expiry %s: %s", alg, ckr.Status, ckr.Message))
+		if verify && cert != nil {
+			if verifyServerName(cert.subjects, verifyName) {
+				msgs = append(msgs, fmt.Sprintf("%s verify OK: servername:%s", alg, verifyName))
+			} else {
+				status = worseStatus(status, checkers.CRITICAL)
+				ckr = checkers.Critical(servernameMismatch(verifyName, cert.subjects))
+				msgs = append(msgs, fmt.Sprintf("%s verify CRITICAL: %s", alg, ckr.Message))
+			}
+		}
+		results = append(results, targetResult{fmt.Sprintf("%s (%s)", endpoint(opts), alg), ckr, cert})
+	}
+	return checkers.NewChecker(status, strings.Join(msgs, "; ")), results
+}
+
+type targetResult struct {
+	endpoint string
+	ckr      *checkers.Checker
+	cert     *certInfo
+}
+
+// checkSRV checks every endpoint discovered by opts.SRV and aggregates the results
+func checkSRV(ctx context.Context, opts CheckOptions) (*checkers.Checker, []targetResult) {
+	_, addrs, err := net.LookupSRV("", "", opts.SRV)
+	if err != nil {
+		return checkers.Unknown(fmt.Sprintf("SRV lookup failed: %s", err)), nil
+	}
+	if len(addrs) == 0 {
+		return checkers.Unknown(fmt.Sprintf("no SRV records found for %s", opts.SRV)), nil
+	}
+	addrs = offeredSRV(addrs)
+	if len(addrs) == 0 {
+		return checkers.Critical(fmt.Sprintf("%s: service is not offered (SRV target \".\")", opts.SRV)), nil
+	}
+
+	var deadline time.Time
+	if opts.Deadline > 0 {
+		deadline = time.Now().Add(opts.Deadline)
+	}
+
+	status := checkers.OK
+	msgs := make([]string, 0, len(addrs))
+	results := make([]targetResult, 0, len(addrs))
+	for _, addr := range addrs {
+		o := opts
+		o.SRV = ""
+		o.Host = strings.TrimSuffix(addr.Target, ".")
+		o.Port = strconv.Itoa(int(addr.Port))
+		if o.ServerName == "" {
+			o.ServerName = srvDomain(opts.SRV)
+		}
+		if !deadline.IsZero() {
+			o.Deadline = time.Until(deadline)
+			if o.Deadline < o.Timeout {
+				o.Timeout = o.Deadline
+			}
+		}
+		ckr := checkers.Unknown("check deadline exceeded")
+		var cert *certInfo
+		if o.Timeout > 0 {
+			ckr, cert = checkCertNet(ctx, o)
+		}
+		// a check cut short by the deadline is not a result of its own
+		if ckr.Status != checkers.OK && !deadline.IsZero() && time.Now().After(deadline) {
+			ckr = checkers.Unknown("check deadline exceeded")
+		}
+		status = worseStatus(status, ckr.Status)
+		msgs = append(msgs, fmt.Sprintf("%s %s: %s", net.JoinHostPort(o.Host, o.Port), ckr.Status, ckr.Message))
+		results = append(results, targetResult{endpoint(o), ckr, cert})
+	}
+	return checkers.NewChecker(status, strings.Join(msgs, "; ")), results
+}
+
+// readTargetsFile reads host[:port] targets, each optionally followed by
+// warning= and critical= thresholds, skipping blanks and comments
+func readTargetsFile(name string) ([]string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read targets file: %s", err)
+	}
+	targets := make([]string, 0)
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.Index(l, "#") == 0 {
+			continue
+		}
+		targets = append(targets, l)
+	}
+	return targets, nil
+}
+
+// parseTargets splits the comma separated host[:port] list of --targets and
+// the lines of --targets-file into CheckOptions. A target without a port is
+// checked on every port of the comma separated --port, as is --host when
+// neither is given. The warning= and critical= fields of a targets file line
+// override --warning and --critical for that target.
+func parseTargets(opts CheckOptions) ([]CheckOptions, error) {
+	list := strings.Split(opts.Targets, ",")
+	if opts.Targets == "" && opts.TargetsFile == "" {
+		list = []string{opts.Host}
+	}
+	if opts.TargetsFile != "" {
+		lines, err := readTargetsFile(opts.TargetsFile)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, lines...)
+	}
+	targets := make([]CheckOptions, 0)
+	for _, t := range list {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		o := opts
+		o.Targets = ""
+		o.TargetsFile = ""
+		fields := strings.Fields(t)
+		t = fields[0]
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			var th *Threshold
+			switch kv[0] {
+			case "warning":
+				th = &o.Warn
+			case "critical":
+				th = &o.Crit
+			}
+			if th == nil || len(kv) != 2 {
+				return nil, fmt.Errorf("invalid field %s of target %s: expected warning= or critical=", f, t)
+			}
+			if err := th.UnmarshalFlag(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid %s of target %s: %s", kv[0], t, err)
+			}
+		}
+		o.Host, o.Port = splitHost(t, "")
+		if strings.Contains(t, ":") && net.ParseIP(t) == nil && strings.Index(t, "[") != 0 {
+			host, port, err := net.SplitHostPort(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target %s: %s", t, err)
+			}
+			o.Host, o.Port = host, port
+		}
+		ports := []string{o.Port}
+		if o.Port == "" {
+			ports = strings.Split(opts.Port, ",")
+		}
+		for _, p := range ports {
+			o.Port = strings.TrimSpace(p)
+			targets = append(targets, o)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets in --targets or --targets-file")
+	}
+	return targets, nil
+}
+
+// rateLimiter is a token bucket holding one token, refilled rate times a
+// second. It paces the start of checks and does nothing when rate is not
+// positive.
+type rateLimiter struct {
+	tokens chan struct{}
+	stop   chan struct{}
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	l := &rateLimiter{}
+	if rate <= 0 {
+		return l
+	}
+	l.tokens = make(chan struct{}, 1)
+	l.stop = make(chan struct{})
+	l.tokens <- struct{}{}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case l.tokens <- struct{}{}:
+				default:
+				}
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// wait takes a token. It returns false when ctx is done or the deadline, if
+// not zero, passes first.
+func (l *rateLimiter) wait(ctx context.Context, deadline time.Time) bool {
+	if l.tokens == nil {
+		return true
+	}
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-l.tokens:
+		return true
+	case <-ctx.Done():
+	case <-expired:
+	}
+	return false
+}
+
+func (l *rateLimiter) close() {
+	if l.stop != nil {
+		close(l.stop)
+	}
+}
+
+// checkTargets checks the targets of opts.Targets and opts.TargetsFile with at most
+// opts.Concurrency checks at a time, started at most opts.RateLimit times a
+// second, and reports the failing ones
+func checkTargets(ctx context.Context, opts CheckOptions) (*checkers.Checker, []targetResult) {
+	targets, err := parseTargets(opts)
+	if err != nil {
+		return checkers.Unknown(err.Error()), nil
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	limiter := newRateLimiter(opts.RateLimit)
+	defer limiter.close()
+
+	var deadline time.Time
+	if opts.Deadline > 0 {
+		deadline = time.Now().Add(opts.Deadline)
+	}
+
+	results := make([]targetResult, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, o := range targets {
+		wg.Add(1)
+		go func(i int, o CheckOptions) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if !limiter.wait(ctx, deadline) {
+				results[i] = targetResult{endpoint(o), checkers.Unknown("check deadline exceeded"), nil}
+				return
+			}
+			if !deadline.IsZero() {
+				o.Deadline = time.Until(deadline)
+				if o.Deadline < o.Timeout {
+					o.Timeout = o.Deadline
+				}
+			}
+			ckr := checkers.Unknown("check deadline exceeded")
+			var cert *certInfo
+			if o.Timeout > 0 {
+				ckr, cert = checkTarget(ctx, o)
+			}
+			if ckr.Status != checkers.OK && !deadline.IsZero() && time.Now().After(deadline) {
+				ckr = checkers.Unknown("check deadline exceeded")
+			}
+			// thresholds differ between targets with a targets file
+			if cert != nil && (ckr.Status == checkers.WARNING || ckr.Status == checkers.CRITICAL) {
+				ckr.Message += fmt.Sprintf(" (thresholds: warning %gd, critical %gd)", o.Warn.days(), o.Crit.days())
+			}
+			results[i] = targetResult{endpoint(o), ckr, cert}
+		}(i, o)
+	}
+	wg.Wait()
+
+	status := checkers.OK
+	msgs := make([]string, 0)
+	for _, r := range results {
+		status = worseStatus(status, r.ckr.Status)
+		if r.ckr.Status != checkers.OK {
+			msgs = append(msgs, fmt.Sprintf("%s %s: %s", r.endpoint, r.ckr.Status, r.ckr.Message))
+		}
+	}
+	if len(msgs) == 0 {
+		return checkers.Ok(fmt.Sprintf("all %d targets OK", len(results))), results
+	}
+	return checkers.NewChecker(status, strings.Join(msgs, "; ")), results
+}
+
+// prepareOptions splits the port off the host, applies --inspect-url,
+// --service and --now and checks the input files, for both Run and Check
+func prepareOptions(opts CheckOptions) (CheckOptions, error) {
+	var err error
+	opts.Host, opts.Port = splitHost(opts.Host, opts.Port)
+	if opts.InspectURL != "" {
+		opts, err = applyInspectURL(opts)
+		if err != nil {
+			return opts, err
+		}
+	}
+	if opts.Service != "" {
+		opts = applyService(opts)
+	}
+	if opts.Now != "" {
+		t, err := time.Parse(time.RFC3339, opts.Now)
+		if err != nil {
+			return opts, usageErrorf("invalid --now: %s", err)
+		}
+		opts.Clock = func() time.Time { return t }
+	}
+	if err := checkInputFiles(opts); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Run runs the check-cert-net command with parsed opts, printing the result
+// to stdout, and returns the exit code
+func Run(opts CheckOptions) int {
+	return run(os.Stdout, opts)
+}
+
+// run is Run writing the result to w
+func run(w io.Writer, opts CheckOptions) int {
+	opts, err := prepareOptions(opts)
+	if err != nil {
+		ckr := checkers.Unknown(err.Error())
+		ckr.Name = "check-cert-net"
+		fmt.Fprintln(w, ckr.String())
+		return int(ckr.Status)
+	}
+	if opts.CheckConfig {
+		ckr := checkers.Ok("config ok")
+		if err := checkConfig(opts); err != nil {
+			ckr = checkers.Unknown(err.Error())
+		}
+		ckr.Name = "check-cert-net"
+		fmt.Fprintln(w, ckr.String())
+		return int(ckr.Status)
+	}
+	ctx := context.Background()
+	if opts.PrintExpiry {
+		return printExpiry(ctx, w, opts)
+	}
+	if opts.PrintSPKIPin {
+		return printSPKIPin(ctx, w, opts)
+	}
+	if opts.Watch {
+		return runWatch(w, opts)
+	}
+	var ckr *checkers.Checker
+	var cert *certInfo
+	var results []targetResult
+	if opts.Targets != "" || opts.TargetsFile != "" || strings.Contains(opts.Port, ",") {
+		ckr, results = checkTargets(ctx, opts)
+	} else if opts.SRV != "" {
+		ckr, results = checkSRV(ctx, opts)
+	} else if opts.Both {
+		ckr, results = checkBoth(ctx, opts)
+	} else {
+		ckr, cert = checkCertNet(ctx, opts)
+		name := endpoint(opts)
+		if opts.CertFile != "" {
+			name = opts.CertFile
+		}
+		results = []targetResult{{name, ckr, cert}}
+	}
+	if opts.Now != "" {
+		ckr.Message += fmt.Sprintf(" (simulated now: %s)", opts.Now)
+	}
+	if opts.StatsdAddr != "" {
+		sendStatsd(opts, ckr, cert)
+	}
+	exitCode := int(ckr.Status)
+	if opts.EmergencyExit != 0 && ckr.Status == checkers.CRITICAL {
+		for _, r := range results {
+			if r.cert != nil && isEmergency(opts, r.cert) {
+				exitCode = opts.EmergencyExit
+			}
+		}
+	}
+	if opts.Metric {
+		writeMetrics(w, opts, results)
+		return exitCode
+	}
+	if opts.Format != "text" {
+		switch opts.Format {
+		case "csv":
+			err = writeCSV(w, opts, results)
+		case "json":
+			err = writeJSON(w, opts, ckr, results)
+		case "jsonl":
+			err = writeJSONL(w, opts, results)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		return exitCode
+	}
+	// an UNKNOWN result, e.g. a failed --template render, has no valid days
+	if cert != nil && ckr.Status != checkers.UNKNOWN && !opts.NoPerfdata {
+		ckr.Message += fmt.Sprintf(" | days=%d;%g;%g;;", daysRemaining(opts, cert), opts.Warn.days(), opts.Crit.days())
+	}
+	ckr.Name = "check-cert-net"
+	fmt.Fprintln(w, ckr.String())
+	return exitCode
+}
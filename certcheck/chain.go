@@ -0,0 +1,101 @@
+package certcheck
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// needsChain reports whether the chain sent by the server has to be read
+func needsChain(opts CheckOptions) bool {
+	return opts.CheckChain || opts.Dane || opts.RequireRoot != "" || opts.SHA1InChain || opts.CompleteChain || opts.LeafInIssuer || opts.IncludePEM
+}
+
+// issuerInChain returns the cert of the chain that issued the leaf, or nil
+// when the server did not send it
+func issuerInChain(cert *certInfo) *certInfo {
+	leaf, err := x509.ParseCertificate(cert.der)
+	if err != nil {
+		return nil
+	}
+	for _, c := range cert.chain {
+		ic, err := x509.ParseCertificate(c.der)
+		if err == nil && bytes.Equal(ic.RawSubject, leaf.RawIssuer) {
+			return c
+		}
+	}
+	return nil
+}
+
+// sha1Signed returns the leaf and the chain certs signed with SHA-1. Self-signed
+// roots are skipped as their signature is not relied on.
+func sha1Signed(cert *certInfo) []*certInfo {
+	signed := make([]*certInfo, 0)
+	for _, c := range append([]*certInfo{cert}, cert.chain...) {
+		if !c.selfSigned && strings.Contains(strings.ToLower(c.sigAlgorithm), "sha1") {
+			signed = append(signed, c)
+		}
+	}
+	return signed
+}
+
+// rootMatches reports whether root is the one named by --require-root,
+// either by a SHA-256 fingerprint or a substring of the subject
+func rootMatches(root *certInfo, want string) bool {
+	if normalizeFingerprint(want) == root.fingerprint {
+		return true
+	}
+	return strings.Contains(strings.ToLower(root.subject), strings.ToLower(want))
+}
+
+// parseChain parses the certificates after the leaf in s_client -showcerts
+// output or in a PEM file
+func parseChain(out []byte) ([]*certInfo, error) {
+	chain := make([]*certInfo, 0)
+	first := true
+	for {
+		var block *pem.Block
+		block, out = pem.Decode(out)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if first {
+			first = false
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse chain certificate: %s", err)
+		}
+		chain = append(chain, newCertInfo(c))
+	}
+	return chain, nil
+}
+
+// soonestInChain returns the cert in the chain, the leaf included, that expires first
+func soonestInChain(cert *certInfo) *certInfo {
+	soonest := cert
+	for _, c := range cert.chain {
+		if c.notAfter.Before(*soonest.notAfter) {
+			soonest = c
+		}
+	}
+	return soonest
+}
+
+// soonestIntermediate returns the cert in the chain, the leaf excluded, that
+// expires first, or nil when the chain is empty
+func soonestIntermediate(cert *certInfo) *certInfo {
+	var soonest *certInfo
+	for _, c := range cert.chain {
+		if soonest == nil || c.notAfter.Before(*soonest.notAfter) {
+			soonest = c
+		}
+	}
+	return soonest
+}
@@ -0,0 +1,254 @@
+package certcheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const typeTLSA = 52
+
+// tlsaRecord is a TLSA resource record (RFC 6698)
+type tlsaRecord struct {
+	usage        uint8
+	selector     uint8
+	matchingType uint8
+	data         []byte
+}
+
+func (r tlsaRecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.usage, r.selector, r.matchingType, hex.EncodeToString(r.data))
+}
+
+// tlsaName returns the TLSA owner name of a TCP service
+func tlsaName(host, port string) string {
+	return fmt.Sprintf("_%s._tcp.%s", port, strings.TrimSuffix(host, "."))
+}
+
+// nameserver returns the first nameserver of /etc/resolv.conf
+func nameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// dialDNS connects to the nameserver server and sets the deadline of the
+// connection to timeout from now, or to the deadline of ctx if it is sooner
+func dialDNS(ctx context.Context, network, server string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// tlsaQuery builds a DNS query for the TLSA records of name
+func tlsaQuery(name string) []byte {
+	var id [2]byte
+	rand.Read(id[:])
+	var b bytes.Buffer
+	b.Write(id[:])
+	// RD and AD, so that a validating resolver reports the answer as
+	// authenticated (RFC 6840 5.7), one question
+	b.Write([]byte{0x01, 0x20, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b.WriteByte(byte(len(label)))
+		b.WriteString(label)
+	}
+	b.WriteByte(0)
+	binary.Write(&b, binary.BigEndian, uint16(typeTLSA))
+	binary.Write(&b, binary.BigEndian, uint16(1))
+	return b.Bytes()
+}
+
+// skipName returns the offset after the (possibly compressed) name at off
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, fmt.Errorf("truncated DNS response")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		default:
+			off += 1 + l
+		}
+	}
+}
+
+// parseTLSAResponse returns the TLSA records in the answer section of the
+// response msg to query and whether the response is truncated. Responses
+// the resolver did not authenticate with DNSSEC are rejected, as TLSA
+// records without DNSSEC prove nothing (RFC 6698 4.1).
+func parseTLSAResponse(msg, query []byte) ([]tlsaRecord, bool, error) {
+	if len(msg) < 12 {
+		return nil, false, fmt.Errorf("truncated DNS response")
+	}
+	if !bytes.Equal(msg[:2], query[:2]) {
+		return nil, false, fmt.Errorf("DNS response ID does not match the query")
+	}
+	truncated := msg[2]&0x02 != 0
+	switch rcode := msg[3] & 0x0f; rcode {
+	case 0, 3:
+	default:
+		return nil, truncated, fmt.Errorf("DNS query failed with rcode %d", rcode)
+	}
+	if !truncated && msg[3]&0x20 == 0 {
+		return nil, false, fmt.Errorf("TLSA response is not DNSSEC validated (AD bit not set); use a validating resolver")
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:6]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:8]))
+	off := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, truncated, err
+		}
+		off += 4
+	}
+	records := make([]tlsaRecord, 0)
+	for i := 0; i < ancount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, truncated, err
+		}
+		if off+10 > len(msg) {
+			return nil, truncated, fmt.Errorf("truncated DNS response")
+		}
+		typ := binary.BigEndian.Uint16(msg[off : off+2])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8 : off+10]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, truncated, fmt.Errorf("truncated DNS response")
+		}
+		rdata := msg[off : off+rdlen]
+		off += rdlen
+		if typ != typeTLSA || len(rdata) < 3 {
+			continue
+		}
+		records = append(records, tlsaRecord{rdata[0], rdata[1], rdata[2], append([]byte{}, rdata[3:]...)})
+	}
+	return records, truncated, nil
+}
+
+// lookupTLSA queries the TLSA records of name, over TCP when the UDP response is truncated
+func lookupTLSA(ctx context.Context, name, server string, timeout time.Duration) ([]tlsaRecord, error) {
+	query := tlsaQuery(name)
+	conn, err := dialDNS(ctx, "udp", server, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	records, truncated, err := parseTLSAResponse(buf[:n], query)
+	if err != nil || !truncated {
+		return records, err
+	}
+
+	tconn, err := dialDNS(ctx, "tcp", server, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	defer tconn.Close()
+	var req bytes.Buffer
+	binary.Write(&req, binary.BigEndian, uint16(len(query)))
+	req.Write(query)
+	if _, err := tconn.Write(req.Bytes()); err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	var l uint16
+	if err := binary.Read(tconn, binary.BigEndian, &l); err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(tconn, msg); err != nil {
+		return nil, fmt.Errorf("TLSA lookup failed: %s", err)
+	}
+	records, _, err = parseTLSAResponse(msg, query)
+	return records, err
+}
+
+// tlsaMatches reports whether the record matches the DER encoded cert
+func tlsaMatches(r tlsaRecord, der []byte) bool {
+	data := der
+	if r.selector == 1 {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return false
+		}
+		data = c.RawSubjectPublicKeyInfo
+	} else if r.selector != 0 {
+		return false
+	}
+	switch r.matchingType {
+	case 0:
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+	return bytes.Equal(data, r.data)
+}
+
+// daneMatches reports whether any record matches the cert. PKIX-EE and
+// DANE-EE records are matched against the leaf, PKIX-TA and DANE-TA records
+// against the certs of the chain. The PKIX usages are not validated against
+// the system trust store.
+func daneMatches(records []tlsaRecord, cert *certInfo) bool {
+	for _, r := range records {
+		switch r.usage {
+		case 1, 3:
+			if tlsaMatches(r, cert.der) {
+				return true
+			}
+		case 0, 2:
+			for _, c := range cert.chain {
+				if tlsaMatches(r, c.der) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
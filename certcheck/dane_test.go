@@ -0,0 +1,156 @@
+package certcheck
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const testCertPEM = `-----BEGIN CERTIFICATE-----
+MIIBijCCATGgAwIBAgIUKJhpQ04sBq4S++PjFZdt6eUFhiIwCgYIKoZIzj0EAwIw
+GzEZMBcGA1UEAwwQbWFpbC5leGFtcGxlLmNvbTAeFw0yNjEwMTQwOTI5MDlaFw0z
+NjEwMTEwOTI5MDlaMBsxGTAXBgNVBAMMEG1haWwuZXhhbXBsZS5jb20wWTATBgcq
+hkjOPQIBBggqhkjOPQMBBwNCAAS7uj/5LlF5zSqgq5b/kxI+iSbBQWLJRo+vVaQH
+KkcKHKlSYhPQMCDNdE0C+c+f4R12Hdpeodm41euuOG3lKTfco1MwUTAdBgNVHQ4E
+FgQU+lwRkhtcoVv7XOk4vsuSl5pcES0wHwYDVR0jBBgwFoAU+lwRkhtcoVv7XOk4
+vsuSl5pcES0wDwYDVR0TAQH/BAUwAwEB/zAKBggqhkjOPQQDAgNHADBEAiBlslxE
++FKuN4EG+ECfMZV3PLbIcnOxLzclAejonCbM5AIgZke6bY7J9WxJUjF0j++mt4nZ
+K/iunAuHhE0xlo+TAa4=
+-----END CERTIFICATE-----`
+
+// tlsaResponse answers query with the given TLSA rdata
+func tlsaResponse(query []byte, rdatas ...[]byte) []byte {
+	msg := append([]byte{}, query[:2]...)
+	msg = append(msg, 0x81, 0xa0, 0x00, 0x01)
+	msg = append(msg, byte(len(rdatas)>>8), byte(len(rdatas)), 0x00, 0x00, 0x00, 0x00)
+	msg = append(msg, query[12:]...)
+	for _, rdata := range rdatas {
+		// pointer to the question name
+		msg = append(msg, 0xc0, 0x0c, 0x00, typeTLSA, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10)
+		l := make([]byte, 2)
+		binary.BigEndian.PutUint16(l, uint16(len(rdata)))
+		msg = append(msg, l...)
+		msg = append(msg, rdata...)
+	}
+	return msg
+}
+
+func testCertDER(t *testing.T) []byte {
+	block, _ := pem.Decode([]byte(testCertPEM))
+	if block == nil {
+		t.Fatal("invalid test certificate")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		t.Fatal(err)
+	}
+	return block.Bytes
+}
+
+// writeTempFile writes content to a temporary file that is removed when the
+// test finishes and returns its name
+func writeTempFile(t *testing.T, pattern, content string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// writeTempCert writes testCertPEM to a temporary file and returns its name
+func writeTempCert(t *testing.T) string {
+	t.Helper()
+	return writeTempFile(t, "cert", testCertPEM)
+}
+
+func TestLookupTLSAAndMatch(t *testing.T) {
+	der := testCertDER(t)
+	c, _ := x509.ParseCertificate(der)
+	spki := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		conn.WriteTo(tlsaResponse(buf[:n], append([]byte{3, 1, 1}, spki[:]...)), addr)
+	}()
+
+	records, err := lookupTLSA(context.Background(), tlsaName("mail.example.com", "25"), conn.LocalAddr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].String()[:6] != "3 1 1 " {
+		t.Fatalf("unexpected records: %v", records)
+	}
+	if !daneMatches(records, &certInfo{der: der}) {
+		t.Fatal("DANE-EE SPKI SHA-256 record does not match")
+	}
+	records[0].data[0] ^= 0xff
+	if daneMatches(records, &certInfo{der: der}) {
+		t.Fatal("modified record should not match")
+	}
+	full := sha256.Sum256(der)
+	ta := tlsaRecord{2, 0, 1, full[:]}
+	if daneMatches([]tlsaRecord{ta}, &certInfo{der: der}) {
+		t.Fatal("DANE-TA record should not match the leaf")
+	}
+	if !daneMatches([]tlsaRecord{ta}, &certInfo{chain: []*certInfo{{der: der}}}) {
+		t.Fatal("DANE-TA record does not match the chain")
+	}
+}
+
+func TestLookupTLSAContextDeadline(t *testing.T) {
+	// a nameserver that never answers
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := lookupTLSA(ctx, tlsaName("mail.example.com", "25"), conn.LocalAddr().String(), 5*time.Second); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the deadline of ctx is not enforced: %s", elapsed)
+	}
+}
+
+func TestParseTLSAResponseRejectsUnauthenticated(t *testing.T) {
+	query := tlsaQuery(tlsaName("mail.example.com", "25"))
+	res := tlsaResponse(query, []byte{3, 1, 1, 0})
+	if _, _, err := parseTLSAResponse(res, query); err != nil {
+		t.Fatal(err)
+	}
+	res[3] &^= 0x20
+	if _, _, err := parseTLSAResponse(res, query); err == nil {
+		t.Fatal("response without the AD bit should be rejected")
+	}
+	res[3] |= 0x20
+	res[0] ^= 0xff
+	if _, _, err := parseTLSAResponse(res, query); err == nil {
+		t.Fatal("response with another ID should be rejected")
+	}
+}
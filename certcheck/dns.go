@@ -0,0 +1,153 @@
+package certcheck
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// lookupTXTPins returns SHA-256 fingerprints published in the TXT records of name.
+func lookupTXTPins(ctx context.Context, name string) ([]string, error) {
+	txts, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("TXT lookup failed: %s", err)
+	}
+	pins := parseTXTPins(txts)
+	if len(pins) == 0 {
+		return nil, fmt.Errorf("no SHA-256 fingerprint found in TXT records of %s", name)
+	}
+	return pins, nil
+}
+
+// parseTXTPins extracts the fingerprints of TXT records. A record holds one
+// or more fingerprints separated by spaces, each either "sha256=<hex>" or
+// bare hex. Anything else is skipped.
+func parseTXTPins(txts []string) []string {
+	pins := make([]string, 0)
+	for _, txt := range txts {
+		for _, field := range strings.Fields(txt) {
+			fp := normalizeFingerprint(strings.TrimPrefix(field, "sha256="))
+			if len(fp) != 64 {
+				continue
+			}
+			if _, err := hex.DecodeString(fp); err != nil {
+				continue
+			}
+			pins = append(pins, fp)
+		}
+	}
+	return pins
+}
+
+// maxCNAMEHops bounds the CNAME chain followed by resolveCNAME
+const maxCNAMEHops = 8
+
+// lookupCNAMEHop asks server for the CNAME record of name and returns its
+// target, or "" when name has no CNAME or does not exist in DNS
+func lookupCNAMEHop(ctx context.Context, name, server string, timeout time.Duration) (string, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return "", err
+	}
+	var id [2]byte
+	rand.Read(id[:])
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET}},
+	}
+	b, err := query.Pack()
+	if err != nil {
+		return "", err
+	}
+	conn, err := dialDNS(ctx, "udp", server, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write(b); err != nil {
+		return "", err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	var res dnsmessage.Message
+	if err := res.Unpack(buf[:n]); err != nil {
+		return "", err
+	}
+	if res.ID != query.ID {
+		return "", fmt.Errorf("DNS response ID does not match the query")
+	}
+	switch res.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		// left to LookupHost, which also reads /etc/hosts
+		return "", nil
+	default:
+		return "", fmt.Errorf("DNS query failed with %s", res.RCode)
+	}
+	for _, a := range res.Answers {
+		if c, ok := a.Body.(*dnsmessage.CNAMEResource); ok && strings.EqualFold(a.Header.Name.String(), qname.String()) {
+			return strings.TrimSuffix(c.CNAME.String(), "."), nil
+		}
+	}
+	return "", nil
+}
+
+// resolveCNAME follows the CNAME chain of host one hop at a time with server
+// and returns every name of the chain, ending with an address of the last one
+func resolveCNAME(ctx context.Context, host, server string, timeout time.Duration) ([]string, error) {
+	chain := []string{host}
+	name := host
+	for i := 0; ; i++ {
+		cname, err := lookupCNAMEHop(ctx, name, server, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve CNAME of %s: %s", name, err)
+		}
+		if cname == "" {
+			break
+		}
+		if i >= maxCNAMEHops {
+			return nil, fmt.Errorf("CNAME chain of %s is longer than %d hops", host, maxCNAMEHops)
+		}
+		chain = append(chain, cname)
+		name = cname
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve %s: %s", name, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address found for %s", name)
+	}
+	return append(chain, addrs[0]), nil
+}
+
+// srvDomain returns the domain part of an SRV name (_service._proto.domain)
+func srvDomain(name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	if len(labels) > 2 && strings.Index(labels[0], "_") == 0 && strings.Index(labels[1], "_") == 0 {
+		return strings.Join(labels[2:], ".")
+	}
+	return strings.Join(labels, ".")
+}
+
+// offeredSRV drops the SRV records whose target is ".", which tells the
+// service is decidedly not available at the domain (RFC 2782)
+func offeredSRV(addrs []*net.SRV) []*net.SRV {
+	offered := make([]*net.SRV, 0, len(addrs))
+	for _, a := range addrs {
+		if a.Target != "." && a.Target != "" {
+			offered = append(offered, a)
+		}
+	}
+	return offered
+}
@@ -0,0 +1,120 @@
+package certcheck
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// jsonExtensions are the X509v3 extensions of the leaf in the json output
+type jsonExtensions struct {
+	BasicConstraints      *jsonBasicConstraints `json:"basic_constraints,omitempty"`
+	KeyUsage              []string              `json:"key_usage,omitempty"`
+	ExtKeyUsage           []string              `json:"ext_key_usage,omitempty"`
+	SubjectAltNames       []string              `json:"subject_alt_names,omitempty"`
+	AuthorityKeyID        string                `json:"authority_key_id,omitempty"`
+	SubjectKeyID          string                `json:"subject_key_id,omitempty"`
+	CRLDistributionPoints []string              `json:"crl_distribution_points,omitempty"`
+	AuthorityInfoAccess   *jsonAIA              `json:"authority_info_access,omitempty"`
+	Policies              []string              `json:"policies,omitempty"`
+}
+
+type jsonBasicConstraints struct {
+	CA      bool `json:"ca"`
+	PathLen *int `json:"path_len,omitempty"`
+}
+
+type jsonAIA struct {
+	OCSP      []string `json:"ocsp,omitempty"`
+	CAIssuers []string `json:"ca_issuers,omitempty"`
+}
+
+// key usages by bit, named as openssl does
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "nonRepudiation"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "anyExtendedKeyUsage",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// colonHex formats a key identifier as openssl does (AB:CD:...)
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = fmt.Sprintf("%02X", c)
+	}
+	return strings.Join(parts, ":")
+}
+
+// certExtensions returns the extensions of the DER encoded cert, or nil
+// when it cannot be parsed
+func certExtensions(der []byte) *jsonExtensions {
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+	e := &jsonExtensions{
+		AuthorityKeyID:        colonHex(c.AuthorityKeyId),
+		SubjectKeyID:          colonHex(c.SubjectKeyId),
+		CRLDistributionPoints: c.CRLDistributionPoints,
+	}
+	if c.BasicConstraintsValid {
+		e.BasicConstraints = &jsonBasicConstraints{CA: c.IsCA}
+		if c.MaxPathLen > 0 || c.MaxPathLenZero {
+			pathLen := c.MaxPathLen
+			e.BasicConstraints.PathLen = &pathLen
+		}
+	}
+	for _, ku := range keyUsageNames {
+		if c.KeyUsage&ku.usage != 0 {
+			e.KeyUsage = append(e.KeyUsage, ku.name)
+		}
+	}
+	for _, eku := range c.ExtKeyUsage {
+		name, ok := extKeyUsageNames[eku]
+		if !ok {
+			name = fmt.Sprintf("unknown (%d)", eku)
+		}
+		e.ExtKeyUsage = append(e.ExtKeyUsage, name)
+	}
+	for _, oid := range c.UnknownExtKeyUsage {
+		e.ExtKeyUsage = append(e.ExtKeyUsage, oid.String())
+	}
+	for _, n := range c.DNSNames {
+		e.SubjectAltNames = append(e.SubjectAltNames, "DNS:"+n)
+	}
+	for _, ip := range c.IPAddresses {
+		e.SubjectAltNames = append(e.SubjectAltNames, "IP:"+ip.String())
+	}
+	for _, m := range c.EmailAddresses {
+		e.SubjectAltNames = append(e.SubjectAltNames, "email:"+m)
+	}
+	for _, u := range c.URIs {
+		e.SubjectAltNames = append(e.SubjectAltNames, "URI:"+u.String())
+	}
+	if len(c.OCSPServer) > 0 || len(c.IssuingCertificateURL) > 0 {
+		e.AuthorityInfoAccess = &jsonAIA{OCSP: c.OCSPServer, CAIssuers: c.IssuingCertificateURL}
+	}
+	for _, oid := range c.PolicyIdentifiers {
+		e.Policies = append(e.Policies, oid.String())
+	}
+	return e
+}
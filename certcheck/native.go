@@ -0,0 +1,636 @@
+package certcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// attribute names as printed by openssl
+var oidNames = map[string]string{
+	"2.5.4.3":              "CN",
+	"2.5.4.5":              "serialNumber",
+	"2.5.4.6":              "C",
+	"2.5.4.7":              "L",
+	"2.5.4.8":              "ST",
+	"2.5.4.9":              "street",
+	"2.5.4.10":             "O",
+	"2.5.4.11":             "OU",
+	"2.5.4.17":             "postalCode",
+	"1.2.840.113549.1.9.1": "emailAddress",
+	"1.2.840.113549.1.9.2": "unstructuredName",
+	"1.2.840.113549.1.9.8": "unstructuredAddress",
+}
+
+// formatDN formats name like the openssl one-line DN ("CN = foo, O = bar")
+func formatDN(name pkix.Name) string {
+	rdns := make([]string, 0, len(name.Names))
+	for _, atv := range name.Names {
+		k := atv.Type.String()
+		if n, ok := oidNames[k]; ok {
+			k = n
+		}
+		v := strings.NewReplacer(`\`, `\\`, ",", `\,`, "+", `\+`).Replace(fmt.Sprint(atv.Value))
+		rdns = append(rdns, fmt.Sprintf("%s = %s", k, v))
+	}
+	return strings.Join(rdns, ", ")
+}
+
+// formatSerial formats the serial as colon separated hex like openssl
+func formatSerial(cert *x509.Certificate) string {
+	b := cert.SerialNumber.Bytes()
+	hexes := make([]string, 0, len(b))
+	for _, c := range b {
+		hexes = append(hexes, fmt.Sprintf("%02x", c))
+	}
+	return strings.Join(hexes, ":")
+}
+
+// parseSCTTimestamps returns the timestamps of the embedded SCT list (RFC 6962)
+func parseSCTTimestamps(cert *x509.Certificate) []time.Time {
+	timestamps := make([]time.Time, 0)
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil || len(list) < 2 {
+			return timestamps
+		}
+		list = list[2:]
+		for len(list) >= 2 {
+			n := int(binary.BigEndian.Uint16(list))
+			if len(list) < 2+n {
+				break
+			}
+			sct := list[2 : 2+n]
+			list = list[2+n:]
+			// version(1) log id(32) timestamp(8)
+			if len(sct) < 41 {
+				continue
+			}
+			ms := int64(binary.BigEndian.Uint64(sct[33:41]))
+			timestamps = append(timestamps, time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).UTC())
+		}
+	}
+	return timestamps
+}
+
+// signature algorithm names as printed by openssl
+var sigAlgorithmNames = map[x509.SignatureAlgorithm]string{
+	x509.MD2WithRSA:       "md2WithRSAEncryption",
+	x509.MD5WithRSA:       "md5WithRSAEncryption",
+	x509.SHA1WithRSA:      "sha1WithRSAEncryption",
+	x509.SHA256WithRSA:    "sha256WithRSAEncryption",
+	x509.SHA384WithRSA:    "sha384WithRSAEncryption",
+	x509.SHA512WithRSA:    "sha512WithRSAEncryption",
+	x509.DSAWithSHA1:      "dsaWithSHA1",
+	x509.DSAWithSHA256:    "dsa_with_SHA256",
+	x509.ECDSAWithSHA1:    "ecdsa-with-SHA1",
+	x509.ECDSAWithSHA256:  "ecdsa-with-SHA256",
+	x509.ECDSAWithSHA384:  "ecdsa-with-SHA384",
+	x509.ECDSAWithSHA512:  "ecdsa-with-SHA512",
+	x509.SHA256WithRSAPSS: "rsassaPss",
+	x509.SHA384WithRSAPSS: "rsassaPss",
+	x509.SHA512WithRSAPSS: "rsassaPss",
+	x509.PureEd25519:      "ED25519",
+}
+
+// sigAlgorithmName returns the signature algorithm named like openssl
+func sigAlgorithmName(cert *x509.Certificate) string {
+	if n, ok := sigAlgorithmNames[cert.SignatureAlgorithm]; ok {
+		return n
+	}
+	return cert.SignatureAlgorithm.String()
+}
+
+// publicKeyInfo returns the key algorithm named like openssl and the key size
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsaEncryption", pub.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "id-ecPublicKey", pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "ED25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// newCertInfo builds certInfo from a parsed leaf certificate
+func newCertInfo(cert *x509.Certificate) *certInfo {
+	notAfter := cert.NotAfter.UTC()
+	notBefore := cert.NotBefore.UTC()
+	subjects := make([]string, 0)
+	ms := make(map[string]struct{})
+	if cert.Subject.CommonName != "" {
+		subjects = append(subjects, cert.Subject.CommonName)
+		ms[cert.Subject.CommonName] = struct{}{}
+	}
+	for _, d := range cert.DNSNames {
+		if _, ok := ms[d]; !ok {
+			subjects = append(subjects, d)
+			ms[d] = struct{}{}
+		}
+	}
+	fp := sha256.Sum256(cert.Raw)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	keyAlgorithm, keyBits := publicKeyInfo(cert)
+	curve := ""
+	if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); ok {
+		curve = pub.Curve.Params().Name
+	}
+	return &certInfo{
+		notAfter:      &notAfter,
+		notBefore:     &notBefore,
+		sctTimestamps: parseSCTTimestamps(cert),
+		subjects:      subjects,
+		commonNames:   dnValues(formatDN(cert.Subject), "CN"),
+		subject:       formatDN(cert.Subject),
+		sans:          append([]string{}, cert.DNSNames...),
+		issuer:        formatDN(cert.Issuer),
+		serial:        formatSerial(cert),
+		fingerprint:   fmt.Sprintf("%x", fp),
+		spkiPin:       base64.StdEncoding.EncodeToString(spki[:]),
+		keyAlgorithm:  keyAlgorithm,
+		keyBits:       keyBits,
+		curve:         curve,
+		sigAlgorithm:  sigAlgorithmName(cert),
+		der:           cert.Raw,
+		selfSigned:    bytes.Equal(cert.RawIssuer, cert.RawSubject),
+	}
+}
+
+// protocol versions as printed by openssl
+var protocolNames = map[uint16]string{
+	tls.VersionSSL30: "SSLv3",
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// handshake runs the handshake of conn within the deadline of ctx, and
+// stops it when ctx is canceled
+func handshake(ctx context.Context, conn *tls.Conn) error {
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return conn.Handshake()
+}
+
+// handshakeError classifies a failed handshake. A handshake failure alert
+// with only the suites of --rsa or --ecdsa means no such cert is served.
+func handshakeError(alg string, err error) error {
+	if alg != "" && strings.Contains(err.Error(), "handshake failure") {
+		return fmt.Errorf("no %s certificate served: TLS 1.2 handshake with %s suites failed", alg, alg)
+	}
+	return classifyNetError(err)
+}
+
+// publicKeyAlgorithms maps --rsa and --ecdsa to the expected key algorithm
+var publicKeyAlgorithms = map[string]x509.PublicKeyAlgorithm{
+	"RSA":   x509.RSA,
+	"ECDSA": x509.ECDSA,
+}
+
+// cipherSuites returns the TLS 1.2 suites authenticated by RSA or ECDSA
+func cipherSuites(auth string) []uint16 {
+	suites := make([]uint16, 0)
+	for _, cs := range tls.CipherSuites() {
+		if strings.Contains(cs.Name, "_"+auth+"_") || (auth == "RSA" && strings.Index(cs.Name, "TLS_RSA_") == 0) {
+			suites = append(suites, cs.ID)
+		}
+	}
+	return suites
+}
+
+// classifyNetError maps dial errors to connError
+func classifyNetError(err error) error {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return &connError{"connection timed out"}
+	}
+	var se *os.SyscallError
+	if errors.As(err, &se) {
+		switch se.Err {
+		case syscall.ECONNREFUSED:
+			return &connError{"connection refused"}
+		case syscall.EHOSTUNREACH:
+			return &connError{"no route to host"}
+		}
+	}
+	return err
+}
+
+// certInfoFromFile reads the leaf, and the chain following it, from a PEM file
+func certInfoFromFile(opts CheckOptions) (*certInfo, error) {
+	data, err := ioutil.ReadFile(opts.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	block, rest := pem.Decode(data)
+	for block != nil && block.Type != "CERTIFICATE" {
+		block, rest = pem.Decode(rest)
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no certificate found in %s", opts.CertFile)
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", opts.CertFile, err)
+	}
+	cert := newCertInfo(leaf)
+	if needsChain(opts) {
+		if cert.chain, err = parseChain(data); err != nil {
+			return nil, err
+		}
+	}
+	if opts.VerifyChain {
+		cert.verifyError = verifyPEMChain(data, opts.CAFile, opts.currentTime())
+	}
+	return cert, nil
+}
+
+// requestPath sends a GET of opts.Renegotiate on conn and reads the start of
+// the response. A server renegotiating for the path does so before it
+// answers, and crypto/tls runs the new handshake within the read. crypto/tls
+// rejects a leaf that changes in the new handshake, so the cert read is the
+// one presented before.
+func requestPath(conn *tls.Conn, opts CheckOptions) error {
+	host := opts.ServerName
+	if host == "" {
+		host = opts.Host
+	}
+	if _, err := fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", opts.Renegotiate, host); err != nil {
+		return err
+	}
+	_, err := conn.Read(make([]byte, 4096))
+	return err
+}
+
+// newDialer returns a dialer bound to opts.SourceAddr when given, with the
+// keep-alive period of --tcp-keepalive
+func newDialer(opts CheckOptions) (*net.Dialer, error) {
+	dialer := &net.Dialer{Timeout: opts.Timeout, KeepAlive: opts.TCPKeepAlive}
+	if opts.SourceAddr != "" {
+		if err := checkSourceAddr(opts.SourceAddr); err != nil {
+			return nil, err
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(opts.SourceAddr)}
+	}
+	return dialer, nil
+}
+
+// setNoDelay turns Nagle's algorithm back on for --tcp-nodelay off. Go
+// disables it on every TCP connection by default.
+func setNoDelay(conn net.Conn, opts CheckOptions) {
+	if tc, ok := conn.(*net.TCPConn); ok && opts.TCPNoDelay == "off" {
+		tc.SetNoDelay(false)
+	}
+}
+
+// resolveHost resolves host within opts.DNSTimeout so that a slow resolver
+// is reported as a DNS failure rather than a connection timeout
+func resolveHost(ctx context.Context, opts CheckOptions) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.DNSTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, opts.Host)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("DNS lookup of %s timed out after %s", opts.Host, opts.DNSTimeout)
+		}
+		return "", fmt.Errorf("DNS lookup of %s failed: %s", opts.Host, err)
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("DNS lookup of %s returned no addresses", opts.Host)
+	}
+	return addrs[0], nil
+}
+
+// verifyChains builds the chains from leaf through the intermediates to the
+// roots of caFile, or the system roots, at the time at. The roots are never
+// nil, as Verify may then use a platform verifier fetching the intermediates
+// from the AIA URL; only the intermediates given are used.
+func verifyChains(leaf *x509.Certificate, intermediates []*x509.Certificate, caFile string, at time.Time) ([][]*x509.Certificate, error) {
+	roots, err := rootPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, c := range intermediates {
+		pool.AddCert(c)
+	}
+	return leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: pool,
+		CurrentTime:   at,
+	})
+}
+
+// rootPool returns the certs of caFile, or the system roots without caFile
+func rootPool(caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("could not load the system roots: %s", err)
+		}
+		return roots, nil
+	}
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return roots, nil
+}
+
+// verifyChain verifies leaf with the intermediates like verifyChains. It
+// returns the reason of a failure or "".
+func verifyChain(leaf *x509.Certificate, intermediates []*x509.Certificate, caFile string, at time.Time) string {
+	if _, err := verifyChains(leaf, intermediates, caFile, at); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// presentedCerts parses the leaf and the chain read with it
+func presentedCerts(cert *certInfo) (*x509.Certificate, []*x509.Certificate, error) {
+	leaf, err := x509.ParseCertificate(cert.der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse the leaf: %s", err)
+	}
+	intermediates := make([]*x509.Certificate, 0, len(cert.chain))
+	for _, c := range cert.chain {
+		ic, err := x509.ParseCertificate(c.der)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse chain certificate: %s", err)
+		}
+		intermediates = append(intermediates, ic)
+	}
+	return leaf, intermediates, nil
+}
+
+// chainGap verifies the presented certs of cert without fetching anything
+// and returns, when no issuer is found for the last of them, a description of
+// the cert whose issuer is missing. Other verification errors are returned.
+func chainGap(cert *certInfo, caFile string, at time.Time) (string, error) {
+	leaf, intermediates, err := presentedCerts(cert)
+	if err != nil {
+		return "", err
+	}
+	_, err = verifyChains(leaf, intermediates, caFile, at)
+	if err == nil {
+		return "", nil
+	}
+	if _, ok := err.(x509.UnknownAuthorityError); !ok {
+		return "", err
+	}
+	// follow the issuers through the presented certs up to the last one
+	last := leaf
+	for i := 0; i < len(intermediates); i++ {
+		next := (*x509.Certificate)(nil)
+		for _, c := range intermediates {
+			if c != last && bytes.Equal(c.RawSubject, last.RawIssuer) {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		last = next
+	}
+	if bytes.Equal(last.RawIssuer, last.RawSubject) {
+		// complete, only the root is not trusted
+		return "", fmt.Errorf("root %s is sent but not trusted", formatDN(last.Subject))
+	}
+	return fmt.Sprintf("issuer %s of %s is neither sent nor a trusted root", formatDN(last.Issuer), formatDN(last.Subject)), nil
+}
+
+// verifiedRoots returns the root of every chain the presented certs of
+// cert verify to
+func verifiedRoots(cert *certInfo, caFile string, at time.Time) ([]*certInfo, error) {
+	leaf, intermediates, err := presentedCerts(cert)
+	if err != nil {
+		return nil, err
+	}
+	chains, err := verifyChains(leaf, intermediates, caFile, at)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]*certInfo, 0, len(chains))
+	for _, chain := range chains {
+		roots = append(roots, newCertInfo(chain[len(chain)-1]))
+	}
+	return roots, nil
+}
+
+// verifyPEMChain verifies the leaf and the chain following it in a PEM file
+func verifyPEMChain(data []byte, caFile string, at time.Time) string {
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err.Error()
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return "no certificate found"
+	}
+	return verifyChain(certs[0], certs[1:], caFile, at)
+}
+
+// checkNativeOpts rejects option combinations getCertInfoNative cannot handle
+func checkNativeOpts(opts CheckOptions) error {
+	if opts.RSA && opts.ECDSA {
+		return usageErrorf("cannot use --rsa and --ecdsa at the same time")
+	}
+	if opts.StartTLS != "" {
+		return usageErrorf("--starttls is not supported with --native")
+	}
+	if opts.CheckOCSP {
+		return usageErrorf("--check-ocsp is not supported with --native")
+	}
+	if opts.ProxyAuth != "" && opts.Proxy == "" {
+		return usageErrorf("--proxy-auth requires --proxy")
+	}
+	if opts.SOCKS5 != "" && opts.Proxy != "" {
+		return usageErrorf("cannot use --socks5 and --proxy at the same time")
+	}
+	if (opts.ClientCert == "") != (opts.ClientKey == "") {
+		return usageErrorf("--client-cert and --client-key must be given together")
+	}
+	return nil
+}
+
+// getCertInfoNative fetches the leaf certificate with crypto/tls
+func getCertInfoNative(ctx context.Context, opts CheckOptions) (*certInfo, error) {
+	if opts.CertFile != "" {
+		return certInfoFromFile(opts)
+	}
+	if err := checkNativeOpts(opts); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	config := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: true,
+	}
+	if opts.ALPN != "" {
+		config.NextProtos = strings.Split(opts.ALPN, ",")
+	}
+	if opts.Renegotiate != "" {
+		// TLS 1.3 has no renegotiation
+		config.Renegotiation = tls.RenegotiateFreelyAsClient
+		config.MaxVersion = tls.VersionTLS12
+	}
+	if opts.ClientCert != "" {
+		pair, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, usageErrorf("could not load client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	dialer, err := newDialer(opts)
+	if err != nil {
+		return nil, err
+	}
+	alg := authAlgorithm(opts)
+	if alg != "" {
+		// TLS 1.3 suites are not configurable and do not select the cert
+		config.CipherSuites = cipherSuites(alg)
+		config.MaxVersion = tls.VersionTLS12
+	}
+	addr := net.JoinHostPort(opts.Host, opts.Port)
+	var conn *tls.Conn
+	if path, ok := unixSocket(opts); ok {
+		if opts.SOCKS5 != "" {
+			return nil, usageErrorf("--socks5 cannot be used with a unix socket")
+		}
+		uconn, err := (&net.Dialer{Timeout: opts.Timeout}).DialContext(ctx, "unix", path)
+		if err != nil {
+			return nil, classifyNetError(err)
+		}
+		conn = tls.Client(uconn, config)
+		defer conn.Close()
+		if err := handshake(ctx, conn); err != nil {
+			return nil, handshakeError(alg, err)
+		}
+	} else if opts, err = proxyFromEnvironment(opts); err != nil {
+		return nil, err
+	} else if opts.Proxy != "" || opts.SOCKS5 != "" {
+		dial := dialProxy
+		if opts.SOCKS5 != "" {
+			dial = dialSOCKS5
+		}
+		pconn, err := dial(ctx, dialer, opts, addr)
+		if err != nil {
+			return nil, err
+		}
+		setNoDelay(pconn, opts)
+		if config.ServerName == "" {
+			config.ServerName = opts.Host
+		}
+		conn = tls.Client(pconn, config)
+		defer conn.Close()
+		if err := handshake(ctx, conn); err != nil {
+			return nil, handshakeError(alg, err)
+		}
+	} else {
+		if opts.DNSTimeout > 0 && net.ParseIP(opts.Host) == nil {
+			ip, err := resolveHost(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			if config.ServerName == "" {
+				config.ServerName = opts.Host
+			}
+			addr = net.JoinHostPort(ip, opts.Port)
+		}
+		tconn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, classifyNetError(err)
+		}
+		setNoDelay(tconn, opts)
+		if config.ServerName == "" {
+			config.ServerName = opts.Host
+		}
+		conn = tls.Client(tconn, config)
+		defer conn.Close()
+		if err := handshake(ctx, conn); err != nil {
+			return nil, handshakeError(alg, err)
+		}
+	}
+	if opts.Renegotiate != "" {
+		// a server that sends nothing after renegotiating runs into the
+		// deadline, which is not an error of the renegotiation
+		err := requestPath(conn, opts)
+		if ne, ok := err.(net.Error); err != nil && err != io.EOF && !(ok && ne.Timeout()) {
+			return nil, usageErrorf("renegotiation for GET %s failed: %s", opts.Renegotiate, err)
+		}
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate presented")
+	}
+	if want, ok := publicKeyAlgorithms[alg]; ok && certs[0].PublicKeyAlgorithm != want {
+		return nil, fmt.Errorf("no %s certificate served: got a %s key", alg, certs[0].PublicKeyAlgorithm)
+	}
+	cert := newCertInfo(certs[0])
+	cert.protocol = protocolNames[conn.ConnectionState().Version]
+	cert.cipher = tls.CipherSuiteName(conn.ConnectionState().CipherSuite)
+	if opts.VerifyChain {
+		cert.verifyError = verifyChain(certs[0], certs[1:], opts.CAFile, opts.currentTime())
+	}
+	if needsChain(opts) {
+		cert.chain = make([]*certInfo, 0, len(certs)-1)
+		for _, c := range certs[1:] {
+			cert.chain = append(cert.chain, newCertInfo(c))
+		}
+	}
+	return cert, nil
+}
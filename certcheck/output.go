@@ -0,0 +1,202 @@
+package certcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mackerelio/checkers"
+)
+
+// printExpiry writes only the expiry date to w and returns the exit code
+func printExpiry(ctx context.Context, w io.Writer, opts CheckOptions) int {
+	loc, err := time.LoadLocation(opts.Timezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --timezone: %v\n", err)
+		return int(checkers.UNKNOWN)
+	}
+	cert, err := fetchCert(ctx, opts)
+	if err != nil {
+		return printFetchError(err)
+	}
+	fmt.Fprintln(w, cert.notAfter.In(loc).Format(opts.DateFormat))
+	return 0
+}
+
+// printFetchError prints the error of fetchCert to stderr and returns the
+// exit code of the print modes: UNKNOWN for usage errors, 1 otherwise
+func printFetchError(err error) int {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	if _, ok := err.(*usageError); ok {
+		return int(checkers.UNKNOWN)
+	}
+	return 1
+}
+
+// writeCSV writes a header and one row per target
+func writeCSV(w io.Writer, opts CheckOptions, results []targetResult) error {
+	loc, err := time.LoadLocation(opts.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"endpoint", "expiry", "days", "issuer", "status"})
+	for _, r := range results {
+		expiry, days, issuer := "", "", ""
+		if r.cert != nil {
+			expiry = r.cert.notAfter.In(loc).Format(opts.DateFormat)
+			days = strconv.FormatInt(daysRemaining(opts, r.cert), 10)
+			issuer = r.cert.issuer
+		}
+		cw.Write([]string{r.endpoint, expiry, days, issuer, r.ckr.Status.String()})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var metricNameReplacer = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// metricName names the days remaining metric of an endpoint, e.g.
+// cert.days_remaining.example_com_443
+func metricName(endpoint string) string {
+	return "cert.days_remaining." + metricNameReplacer.ReplaceAllString(endpoint, "_")
+}
+
+// writeMetrics writes days remaining of every target read, in the
+// format of Mackerel metric plugins
+func writeMetrics(w io.Writer, opts CheckOptions, results []targetResult) {
+	ts := opts.currentTime().Unix()
+	for _, r := range results {
+		if r.cert == nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", metricName(r.endpoint), daysRemaining(opts, r.cert), ts)
+	}
+}
+
+// sendStatsd sends days remaining as a gauge and the status as a counter.
+// Errors are ignored so that statsd never changes the check result.
+func sendStatsd(opts CheckOptions, ckr *checkers.Checker, cert *certInfo) {
+	conn, err := net.DialTimeout("udp", opts.StatsdAddr, opts.Timeout)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var buf bytes.Buffer
+	if cert != nil {
+		fmt.Fprintf(&buf, "%s.days_remaining:%d|g\n", opts.StatsdPrefix, daysRemaining(opts, cert))
+	}
+	fmt.Fprintf(&buf, "%s.status.%s:1|c\n", opts.StatsdPrefix, strings.ToLower(ckr.Status.String()))
+	conn.Write(buf.Bytes())
+}
+
+// schemaVersion of the json and jsonl output. Bump it on breaking changes.
+const schemaVersion = 1
+
+type jsonTarget struct {
+	SchemaVersion int             `json:"schema_version"`
+	Endpoint      string          `json:"endpoint"`
+	Status        string          `json:"status"`
+	Message       string          `json:"message"`
+	NotAfter      *string         `json:"not_after,omitempty"`
+	DaysRemaining *int64          `json:"days_remaining,omitempty"`
+	Subjects      []string        `json:"subjects,omitempty"`
+	Issuer        *string         `json:"issuer,omitempty"`
+	Protocol      string          `json:"protocol,omitempty"`
+	Cipher        string          `json:"cipher,omitempty"`
+	Extensions    *jsonExtensions `json:"extensions,omitempty"`
+	ChainPEM      string          `json:"chain_pem,omitempty"`
+}
+
+// chainPEM concatenates the PEM of the leaf and the chain read with it
+func chainPEM(cert *certInfo) string {
+	var b bytes.Buffer
+	for _, c := range append([]*certInfo{cert}, cert.chain...) {
+		if c.der != nil {
+			pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+		}
+	}
+	return b.String()
+}
+
+type jsonResult struct {
+	SchemaVersion int          `json:"schema_version"`
+	Status        string       `json:"status"`
+	Message       string       `json:"message"`
+	Targets       []jsonTarget `json:"targets"`
+}
+
+func newJSONTarget(opts CheckOptions, r targetResult) jsonTarget {
+	t := jsonTarget{
+		SchemaVersion: schemaVersion,
+		Endpoint:      r.endpoint,
+		Status:        r.ckr.Status.String(),
+		Message:       r.ckr.Message,
+	}
+	if r.cert != nil {
+		notAfter := r.cert.notAfter.UTC().Format(time.RFC3339)
+		days := daysRemaining(opts, r.cert)
+		t.NotAfter = &notAfter
+		t.DaysRemaining = &days
+		t.Subjects = r.cert.subjects
+		t.Issuer = &r.cert.issuer
+		t.Protocol = r.cert.protocol
+		t.Cipher = r.cert.cipher
+		if r.cert.der != nil {
+			t.Extensions = certExtensions(r.cert.der)
+		}
+		if opts.IncludePEM {
+			t.ChainPEM = chainPEM(r.cert)
+		}
+	}
+	return t
+}
+
+// writeJSON writes the whole run as one json document
+func writeJSON(w io.Writer, opts CheckOptions, ckr *checkers.Checker, results []targetResult) error {
+	res := jsonResult{
+		SchemaVersion: schemaVersion,
+		Status:        ckr.Status.String(),
+		Message:       ckr.Message,
+		Targets:       make([]jsonTarget, 0, len(results)),
+	}
+	for _, r := range results {
+		res.Targets = append(res.Targets, newJSONTarget(opts, r))
+	}
+	return json.NewEncoder(w).Encode(res)
+}
+
+// writeJSONL writes one json record per target
+func writeJSONL(w io.Writer, opts CheckOptions, results []targetResult) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(newJSONTarget(opts, r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printSPKIPin writes only the HPKP pin of the leaf to w and returns the exit code
+func printSPKIPin(ctx context.Context, w io.Writer, opts CheckOptions) int {
+	cert, err := fetchCert(ctx, opts)
+	if err != nil {
+		return printFetchError(err)
+	}
+	if cert.spkiPin == "" {
+		fmt.Fprintf(os.Stderr, "could not find public key in result\n")
+		return 1
+	}
+	fmt.Fprintf(w, "pin-sha256=\"%s\"\n", cert.spkiPin)
+	return 0
+}
@@ -0,0 +1,89 @@
+package certcheck
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// proxyFromEnvironment fills opts.Proxy or opts.SOCKS5 from HTTPS_PROXY and
+// NO_PROXY unless a proxy is given on the command line
+func proxyFromEnvironment(opts CheckOptions) (CheckOptions, error) {
+	if opts.Proxy != "" || opts.SOCKS5 != "" {
+		return opts, nil
+	}
+	u, err := httpproxy.FromEnvironment().ProxyFunc()(&url.URL{Scheme: "https", Host: net.JoinHostPort(opts.Host, opts.Port)})
+	if err != nil {
+		return opts, usageErrorf("invalid HTTPS_PROXY: %s", err)
+	}
+	if u == nil {
+		return opts, nil
+	}
+	switch u.Scheme {
+	case "http":
+		opts.Proxy = u.Host
+		if u.User != nil && opts.ProxyAuth == "" {
+			pass, _ := u.User.Password()
+			opts.ProxyAuth = u.User.Username() + ":" + pass
+		}
+	case "socks5":
+		opts.SOCKS5 = u.Host
+	default:
+		return opts, usageErrorf("unsupported HTTPS_PROXY scheme: %s", u.Scheme)
+	}
+	return opts, nil
+}
+
+// dialProxy connects to addr through the HTTP CONNECT proxy of opts.Proxy
+func dialProxy(ctx context.Context, dialer *net.Dialer, opts CheckOptions, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", opts.Proxy)
+	if err != nil {
+		return nil, classifyNetError(err)
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if opts.ProxyAuth != "" {
+		req += fmt.Sprintf("Proxy-Authorization: Basic %s\r\n", base64.StdEncoding.EncodeToString([]byte(opts.ProxyAuth)))
+	}
+	req += "\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, classifyNetError(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		conn.Close()
+		return nil, classifyNetError(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT: %s", opts.Proxy, res.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dialSOCKS5 connects to addr through the SOCKS5 proxy of opts.SOCKS5.
+// The proxy resolves the host name.
+func dialSOCKS5(ctx context.Context, dialer *net.Dialer, opts CheckOptions, addr string) (net.Conn, error) {
+	d, err := proxy.SOCKS5("tcp", opts.SOCKS5, nil, dialer)
+	if err != nil {
+		return nil, usageErrorf("invalid --socks5: %s", err)
+	}
+	conn, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, classifyNetError(err)
+	}
+	return conn, nil
+}
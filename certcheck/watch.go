@@ -0,0 +1,67 @@
+package certcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mackerelio/checkers"
+)
+
+// watch checks the targets every opts.Interval until ctx is done and writes
+// a line to w when the status of a target changes. The first round writes
+// the status of every target.
+func watch(ctx context.Context, w io.Writer, opts CheckOptions) {
+	last := make(map[string]checkers.Status)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		_, results := checkTargets(ctx, opts)
+		if ctx.Err() != nil {
+			return
+		}
+		at := time.Now().UTC().Format(time.RFC3339)
+		for _, r := range results {
+			prev, seen := last[r.endpoint]
+			last[r.endpoint] = r.ckr.Status
+			if !seen {
+				fmt.Fprintf(w, "%s %s %s: %s\n", at, r.endpoint, r.ckr.Status, r.ckr.Message)
+			} else if prev != r.ckr.Status {
+				fmt.Fprintf(w, "%s %s %s -> %s: %s\n", at, r.endpoint, prev, r.ckr.Status, r.ckr.Message)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWatch runs watch on w until SIGINT or SIGTERM and returns the exit code
+func runWatch(w io.Writer, opts CheckOptions) int {
+	if opts.Interval <= 0 {
+		ckr := checkers.Unknown("--interval must be positive")
+		ckr.Name = "check-cert-net"
+		fmt.Fprintln(w, ckr.String())
+		return int(ckr.Status)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	watch(ctx, w, opts)
+	return 0
+}
@@ -1,9 +1,12 @@
 package execpipe
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"os"
 	"os/exec"
+	"reflect"
 	"sync"
 )
 
@@ -19,32 +22,123 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	return w.w.Write(p)
 }
 
+// Result : exit status and stderr output of one command in the pipeline
+type Result struct {
+	Args     []string
+	ExitCode int
+	Err      error
+	Stderr   []byte
+}
+
 // Command : Copy from mattn/go-pipeline
-func Command(ctx context.Context, stdout, stderr io.Writer, commands ...[]string) error {
+// stdin, when not nil, is fed to the first command
+func Command(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, commands ...[]string) error {
+	_, err := CommandWithStatus(ctx, stdin, stdout, stderr, commands...)
+	return err
+}
+
+// CommandWithStatus : run the pipeline like Command and report the result of
+// every command. ExitCode is -1 when the command was not started or was killed.
+// The stderr of each command is kept in its Result, and also written to
+// stderr when it is not nil.
+func CommandWithStatus(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, commands ...[]string) ([]Result, error) {
+	return CommandWithStatusEnv(ctx, nil, stdin, stdout, stderr, commands...)
+}
+
+// CommandWithStatusEnv : CommandWithStatus with env, "KEY=value" pairs, added
+// to the environment of every command. Secrets passed this way do not show
+// in the process list as arguments do.
+func CommandWithStatusEnv(ctx context.Context, env []string, stdin io.Reader, stdout, stderr io.Writer, commands ...[]string) ([]Result, error) {
 	cmds := make([]*exec.Cmd, len(commands))
+	results := make([]Result, len(commands))
 	var err error
-	m := &sync.Mutex{}
-	outWriter := &Writer{stdout, m}
-	errWriter := &Writer{stderr, m}
+	// stdout and the stderr shared by every command are locked apart, but
+	// a writer passed as both must be serialized by one lock
+	outWriter := &Writer{stdout, &sync.Mutex{}}
+	errWriter := &Writer{stderr, &sync.Mutex{}}
+	if sameWriter(stdout, stderr) {
+		errWriter.m = outWriter.m
+	}
+	errBufs := make([]bytes.Buffer, len(commands))
 	for i, c := range commands {
+		results[i] = Result{Args: c, ExitCode: -1}
 		cmds[i] = exec.CommandContext(ctx, c[0], c[1:]...)
+		if env != nil {
+			cmds[i].Env = append(os.Environ(), env...)
+		}
 		if i > 0 {
 			if cmds[i].Stdin, err = cmds[i-1].StdoutPipe(); err != nil {
-				return err
+				return results, err
 			}
 		}
-		cmds[i].Stderr = errWriter
+		cmds[i].Stderr = &errBufs[i]
+		if stderr != nil {
+			cmds[i].Stderr = io.MultiWriter(&errBufs[i], errWriter)
+		}
 	}
+	cmds[0].Stdin = stdin
 	cmds[len(cmds)-1].Stdout = outWriter
-	for _, c := range cmds {
+	for i, c := range cmds {
 		if err = c.Start(); err != nil {
-			return err
+			results[i].Err = err
+			kill(cmds[:i])
+			return results, err
+		}
+	}
+
+	// kill every stage once the context is done so that a blocked
+	// command holding the pipe cannot keep Wait from returning
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill(cmds)
+		case <-done:
 		}
+	}()
+
+	var first error
+	for i, c := range cmds {
+		err = c.Wait()
+		results[i].Err = err
+		results[i].Stderr = errBufs[i].Bytes()
+		if c.ProcessState != nil {
+			results[i].ExitCode = c.ProcessState.ExitCode()
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	if ctx.Err() != nil {
+		return results, ctx.Err()
 	}
+	return results, first
+}
+
+// Failed : the first command that did not exit successfully
+func Failed(results []Result) (Result, bool) {
+	for _, r := range results {
+		if r.Err != nil {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
+
+// sameWriter reports whether a and b are the same writer without panicking
+// on writers of a type that cannot be compared
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil || reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func kill(cmds []*exec.Cmd) {
 	for _, c := range cmds {
-		if err = c.Wait(); err != nil {
-			return err
+		if c.Process != nil {
+			c.Process.Kill()
 		}
 	}
-	return nil
 }
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"context"
 	"log"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCommand(t *testing.T) {
@@ -12,6 +15,7 @@ func TestCommand(t *testing.T) {
 	var buf bytes.Buffer
 	err := Command(
 		ctx,
+		nil,
 		&buf,
 		&buf,
 		[]string{"echo", "1"},
@@ -27,6 +31,7 @@ func TestCommand(t *testing.T) {
 	var buf2 bytes.Buffer
 	err = Command(
 		ctx,
+		nil,
 		&buf2,
 		&buf2,
 		[]string{"echo", "1"},
@@ -39,3 +44,119 @@ func TestCommand(t *testing.T) {
 		log.Fatal("output is empty.")
 	}
 }
+
+func TestCommandTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	start := time.Now()
+	err := Command(
+		ctx,
+		nil,
+		&buf,
+		&buf,
+		[]string{"sleep", "10"},
+		[]string{"sleep", "10"},
+		[]string{"cat"},
+	)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("pipeline did not return promptly: %s", elapsed)
+	}
+}
+
+func TestCommandWithStatus(t *testing.T) {
+	var buf bytes.Buffer
+	results, err := CommandWithStatus(
+		context.Background(),
+		nil,
+		&buf,
+		&buf,
+		[]string{"echo", "1"},
+		[]string{"sh", "-c", "cat >/dev/null; exit 3"},
+		[]string{"cat"},
+	)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].ExitCode != 0 || results[2].ExitCode != 0 {
+		t.Fatalf("unexpected exit codes: %d %d", results[0].ExitCode, results[2].ExitCode)
+	}
+	r, ok := Failed(results)
+	if !ok || r.Args[0] != "sh" || r.ExitCode != 3 {
+		t.Fatalf("unexpected failed result: %+v", r)
+	}
+}
+
+func TestCommandWithStatusStderr(t *testing.T) {
+	var buf, ebuf bytes.Buffer
+	results, err := CommandWithStatus(
+		context.Background(),
+		nil,
+		&buf,
+		&ebuf,
+		[]string{"sh", "-c", "echo first >&2; echo 1"},
+		[]string{"sh", "-c", "cat; echo second >&2"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(results[0].Stderr) != "first\n" || string(results[1].Stderr) != "second\n" {
+		t.Fatalf("unexpected stderr: %q %q", results[0].Stderr, results[1].Stderr)
+	}
+	if !strings.Contains(ebuf.String(), "first") || !strings.Contains(ebuf.String(), "second") {
+		t.Fatalf("stderr is not copied to the writer: %q", ebuf.String())
+	}
+	if buf.String() != "1\n" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestCommandStdin(t *testing.T) {
+	var buf bytes.Buffer
+	err := Command(
+		context.Background(),
+		strings.NewReader("QUIT\n"),
+		&buf,
+		&buf,
+		[]string{"cat"},
+		[]string{"tr", "A-Z", "a-z"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "quit\n" {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+}
+
+func TestCommandWithStatusEnv(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := CommandWithStatusEnv(
+		context.Background(),
+		[]string{"EXECPIPE_TEST_SECRET=s3cret"},
+		nil,
+		&buf,
+		nil,
+		[]string{"sh", "-c", "echo $EXECPIPE_TEST_SECRET"},
+		[]string{"sh", "-c", "cat; echo $EXECPIPE_TEST_SECRET; echo $HOME"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "s3cret\ns3cret\n"+os.Getenv("HOME")+"\n" {
+		t.Fatalf("env is not added to the environment of every command: %q", buf.String())
+	}
+}
+
+func TestSameWriter(t *testing.T) {
+	var a, b bytes.Buffer
+	if !sameWriter(&a, &a) || sameWriter(&a, &b) || sameWriter(&a, nil) {
+		t.Fatal("unexpected writer comparison")
+	}
+}
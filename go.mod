@@ -5,4 +5,5 @@ go 1.14
 require (
 	github.com/jessevdk/go-flags v1.4.0
 	github.com/mackerelio/checkers v0.0.0-20200428063449-52cfb2c2c52c
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 )
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/kazeburo/check-cert-net/certcheck"
+)
+
+func TestApplyTimeoutFromEnv(t *testing.T) {
+	if v, ok := os.LookupEnv("CHECK_CERT_TIMEOUT"); ok {
+		defer os.Setenv("CHECK_CERT_TIMEOUT", v)
+	} else {
+		defer os.Unsetenv("CHECK_CERT_TIMEOUT")
+	}
+	tests := []struct {
+		set     bool
+		env     string
+		args    []string
+		want    time.Duration
+		wantErr bool
+	}{
+		{false, "", nil, 5 * time.Second, false},
+		{true, "", nil, 5 * time.Second, false},
+		{true, "12s", nil, 12 * time.Second, false},
+		{true, " 1m ", nil, time.Minute, false},
+		{true, "12", nil, 0, true},
+		{true, "soon", nil, 0, true},
+		{true, "0s", nil, 0, true},
+		{true, "-3s", nil, 0, true},
+		{true, "12s", []string{"--timeout", "3s"}, 3 * time.Second, false},
+		// --timeout wins, so an invalid variable is not even read
+		{true, "soon", []string{"--timeout", "3s"}, 3 * time.Second, false},
+	}
+	for _, tt := range tests {
+		if tt.set {
+			os.Setenv("CHECK_CERT_TIMEOUT", tt.env)
+		} else {
+			os.Unsetenv("CHECK_CERT_TIMEOUT")
+		}
+		opts := certcheck.CheckOptions{}
+		psr := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)
+		if _, err := psr.ParseArgs(append([]string{"-H", "example.com"}, tt.args...)); err != nil {
+			t.Fatal(err)
+		}
+		err := applyTimeoutFromEnv(psr, &opts)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("CHECK_CERT_TIMEOUT=%q %v: expected an error", tt.env, tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("CHECK_CERT_TIMEOUT=%q %v: %s", tt.env, tt.args, err)
+			continue
+		}
+		if opts.Timeout != tt.want {
+			t.Errorf("CHECK_CERT_TIMEOUT=%q %v: timeout %s, want %s", tt.env, tt.args, opts.Timeout, tt.want)
+		}
+	}
+}